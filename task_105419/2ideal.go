@@ -4,9 +4,16 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
+	"log"
 	"math"
 	"math/rand"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,103 +23,758 @@ type Observer interface {
 	Update(price float64)
 }
 
-// BayesianEstimator is an observer that uses Bayesian methods for market analysis.
+// NIGParams holds the hyperparameters of a Normal-Inverse-Gamma distribution
+// over the unknown mean and variance of the observed prices.
+type NIGParams struct {
+	Mu    float64 `json:"mu"`    // Location of the mean
+	Kappa float64 `json:"kappa"` // Pseudo-observations behind Mu
+	Alpha float64 `json:"alpha"` // Shape of the variance
+	Beta  float64 `json:"beta"`  // Scale of the variance
+}
+
+// update returns the conjugate posterior after observing x.
+func (p NIGParams) update(x float64) NIGParams {
+	kappa := p.Kappa + 1
+	return NIGParams{
+		Mu:    (p.Kappa*p.Mu + x) / kappa,
+		Kappa: kappa,
+		Alpha: p.Alpha + 0.5,
+		Beta:  p.Beta + p.Kappa*(x-p.Mu)*(x-p.Mu)/(2*kappa),
+	}
+}
+
+// remove undoes update(x), returning the posterior without observation x.
+func (p NIGParams) remove(x float64) NIGParams {
+	kappa := p.Kappa - 1
+	mu := (p.Kappa*p.Mu - x) / kappa
+	return NIGParams{
+		Mu:    mu,
+		Kappa: kappa,
+		Alpha: p.Alpha - 0.5,
+		Beta:  p.Beta - kappa*(x-mu)*(x-mu)/(2*p.Kappa),
+	}
+}
+
+// discount shrinks the evidence accumulated on top of prior by the factor
+// lambda, leaving the location unchanged.
+func (p NIGParams) discount(prior NIGParams, lambda float64) NIGParams {
+	return NIGParams{
+		Mu:    p.Mu,
+		Kappa: prior.Kappa + lambda*(p.Kappa-prior.Kappa),
+		Alpha: prior.Alpha + lambda*(p.Alpha-prior.Alpha),
+		Beta:  prior.Beta + lambda*(p.Beta-prior.Beta),
+	}
+}
+
+// BayesianEstimator is an observer that keeps a Normal-Inverse-Gamma posterior
+// over the mean and variance of the observed prices.
 type BayesianEstimator struct {
-	mu, sigma   float64 // Prior parameters of the Normal distribution
-	alpha, beta float64 // Prior parameters of the Beta distribution
-	mean        float64 // Estimated mean price
-	count       int     // Number of observed prices
-	mux         sync.Mutex
+	prior      NIGParams // Prior hyperparameters
+	post       NIGParams // Posterior hyperparameters (mu_n, kappa_n, alpha_n, beta_n)
+	count      int       // Number of observed prices
+	forgetting float64   // Weight kept by past evidence on each update; 1 never forgets
+	windowSize int       // Number of recent prices in the posterior; 0 keeps all
+	window     []float64 // Recent prices when windowSize > 0
+	stale      int       // Window removals since the posterior was last rebuilt
+	gate       SignalGate
+	clock      func() time.Time // Stamps decisions, see SetClock
+	mux        sync.Mutex
+
+	rng    *rand.Rand // Source for posterior sampling, see SetRand
+	rngMux sync.Mutex
 }
 
-func NewBayesianEstimator() *BayesianEstimator {
+// NewBayesianEstimator creates an estimator starting from the given prior.
+func NewBayesianEstimator(prior NIGParams) *BayesianEstimator {
 	return &BayesianEstimator{
-		mu:    100.0,
-		sigma: 10.0,
-		alpha: 1.0,
-		beta:  1.0,
+		prior:      prior,
+		post:       prior,
+		forgetting: 1,
+		clock:      time.Now,
 	}
 }
 
-// Update updates the estimator with a new observed price.
+// NewDiscountedEstimator creates an estimator that exponentially discounts past
+// evidence by forgetting (0 < forgetting <= 1) on every update, giving an
+// effective memory of about 1/(1-forgetting) observations.
+func NewDiscountedEstimator(prior NIGParams, forgetting float64) *BayesianEstimator {
+	e := NewBayesianEstimator(prior)
+	e.forgetting = forgetting
+	return e
+}
+
+// NewWindowedEstimator creates an estimator whose posterior only reflects the
+// most recent size observations.
+func NewWindowedEstimator(prior NIGParams, size int) *BayesianEstimator {
+	e := NewBayesianEstimator(prior)
+	e.windowSize = size
+	e.window = make([]float64, 0, size+1)
+	return e
+}
+
+// Update applies the conjugate Normal-Inverse-Gamma update for a new observed price.
 func (e *BayesianEstimator) Update(price float64) {
 	e.mux.Lock()
 	defer e.mux.Unlock()
 
+	if e.forgetting < 1 {
+		e.post = e.post.discount(e.prior, e.forgetting)
+	}
+	e.post = e.post.update(price)
 	e.count++
-	e.mean = (e.alpha*e.mean + price) / (e.alpha + 1)
-	e.alpha++
+
+	if e.windowSize > 0 {
+		e.window = append(e.window, price)
+		if len(e.window) > e.windowSize {
+			e.post = e.post.remove(e.window[0])
+			e.window = append(e.window[:0], e.window[1:]...)
+			e.stale++
+		}
+		// Rebuild from the prior now and then so downdate rounding cannot accumulate
+		if e.stale >= e.windowSize {
+			e.rebuild()
+		}
+	}
+}
+
+// rebuild recomputes the posterior from the prior and the current window.
+func (e *BayesianEstimator) rebuild() {
+	e.post = e.prior
+	for _, x := range e.window {
+		e.post = e.post.update(x)
+	}
+	e.stale = 0
+}
+
+// Reset discards everything learned and starts again from prior.
+func (e *BayesianEstimator) Reset(prior NIGParams) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.prior, e.post, e.count = prior, prior, 0
+	e.window = e.window[:0]
+	e.stale = 0
+}
+
+// Params returns the current posterior hyperparameters.
+func (e *BayesianEstimator) Params() NIGParams {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.post
+}
+
+// Count returns the number of observed prices.
+func (e *BayesianEstimator) Count() int {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.count
+}
+
+// Posterior returns the Student-t marginal posterior of the mean.
+func (e *BayesianEstimator) Posterior() MeanPosterior {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.post.meanPosterior(e.count)
 }
 
 // ProbabilityOfHigherMean calculates the probability that the true mean price is higher than the given threshold.
 func (e *BayesianEstimator) ProbabilityOfHigherMean(threshold float64) float64 {
-	// Using a simplified normal distribution approximation instead of incomplete gamma
-	standardError := e.sigma / math.Sqrt(float64(e.count))
-	z := (e.mean - threshold) / standardError
-	return 0.5 * (1 + math.Erf(z/math.Sqrt(2)))
+	return e.Posterior().ProbabilityAbove(threshold)
+}
+
+// ProbabilityAbove implements Model.
+func (e *BayesianEstimator) ProbabilityAbove(threshold float64) float64 {
+	return e.ProbabilityOfHigherMean(threshold)
 }
 
-// GenerateSignal generates a trading signal.
-func (e *BayesianEstimator) GenerateSignal(threshold float64, confidence float64) int {
-	prob := e.ProbabilityOfHigherMean(threshold)
-	if prob > confidence {
-		return 1 // Buy signal
-	} else if prob < 1-confidence {
-		return -1 // Sell signal
+// CredibleInterval implements Model.
+func (e *BayesianEstimator) CredibleInterval(level float64) (lo, hi float64) {
+	return e.Posterior().CredibleInterval(level)
+}
+
+// SetGate installs a gate that may veto the signals of GenerateSignal.
+func (e *BayesianEstimator) SetGate(gate SignalGate) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.gate = gate
+}
+
+// SetClock replaces the clock that stamps decisions, so a replay's decisions
+// carry the time of the bar they were made on.
+func (e *BayesianEstimator) SetClock(clock func() time.Time) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.clock = clock
+}
+
+// GenerateSignal generates a trading signal together with the posterior evidence behind it.
+func (e *BayesianEstimator) GenerateSignal(threshold float64, confidence float64) Decision {
+	e.mux.Lock()
+	gate, clock := e.gate, e.clock
+	e.mux.Unlock()
+	d := decide(e, threshold, confidence, clock())
+	if gate != nil && d.Signal != 0 {
+		if ok, reason := gate.Allow(d.Signal); !ok {
+			d.Signal = 0
+			d.Suppressed = reason
+		}
 	}
-	return 0 // No signal
+	return d
 }
 
 // DataSource provides real-time market data updates to observers.
 type DataSource struct {
-	observers    []Observer
+	observerSet
 	tickInterval time.Duration
-	mux          sync.RWMutex
+	rng          *rand.Rand
+	process      PriceProcess
+	price        float64
 }
 
+// NewDataSource creates a source of normal noise around 105, seeded from the clock.
 func NewDataSource(tickInterval time.Duration) *DataSource {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return NewSyntheticSource(tickInterval, rng, NoiseProcess{Mean: 105, StdDev: 10}, 105)
+}
+
+// NewSyntheticSource creates a source driven by process from the start price.
+// All randomness comes from rng, so a seeded rng yields the same tick
+// sequence on every run.
+func NewSyntheticSource(tickInterval time.Duration, rng *rand.Rand, process PriceProcess, start float64) *DataSource {
 	return &DataSource{
 		tickInterval: tickInterval,
+		rng:          rng,
+		process:      process,
+		price:        start,
 	}
 }
 
-// Attach adds an observer to the data source.
-func (ds *DataSource) Attach(observer Observer) {
-	ds.mux.Lock()
-	defer ds.mux.Unlock()
-	ds.observers = append(ds.observers, observer)
+// next advances the process by one tick. Only the Start goroutine or a
+// Generate caller may use it at a time.
+func (ds *DataSource) next() float64 {
+	ds.price = ds.process.Next(ds.rng, ds.price)
+	return ds.price
 }
 
-// Start generates random price data and notifies observers at the specified tick interval.
-func (ds *DataSource) Start() {
-	rand.Seed(time.Now().UnixNano())
+// Generate returns the next n prices without notifying observers, for
+// scenario tests that need the tick sequence itself.
+func (ds *DataSource) Generate(n int) []float64 {
+	prices := make([]float64, n)
+	for i := range prices {
+		prices[i] = ds.next()
+	}
+	return prices
+}
+
+// Start generates prices and notifies observers at the specified tick
+// interval until ctx is cancelled.
+func (ds *DataSource) Start(ctx context.Context) {
 	ticker := time.NewTicker(ds.tickInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		price := rand.NormFloat64()*10 + 105
-		ds.mux.RLock()
-		for _, observer := range ds.observers {
-			observer.Update(price)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ds.notify(ctx, ds.next())
 		}
-		ds.mux.RUnlock()
 	}
 }
 
 func main() {
-	ds := NewDataSource(time.Second)
-	estimator := NewBayesianEstimator()
-	ds.Attach(estimator)
+	speed := flag.Float64("speed", 0, "replay speed for OHLCV files given as arguments (0 = as fast as possible)")
+	snapshotPath := flag.String("snapshot", "", "file to restore the estimator from and periodically save it to")
+	forgetting := flag.Float64("forgetting", 1, "per-update weight kept by past observations (1 = never forget)")
+	window := flag.Int("window", 0, "only use the most recent N observations (0 = all)")
+	resetOnChange := flag.Bool("reset-on-change", false, "re-prior the estimator when a change point is detected")
+	auditPath := flag.String("audit", "", "append every trading decision to this JSONL file")
+	returns := flag.String("returns", "", "model simple or log returns instead of price levels")
+	normalize := flag.Bool("normalize", false, "divide returns by their EWMA volatility")
+	seed := flag.Int64("seed", 0, "seed for the synthetic market and posterior sampling (0 = time-based)")
+	process := flag.String("process", "noise", "synthetic market model: noise, gbm, jump or regime")
+	tick := flag.Duration("tick", time.Second, "synthetic market tick interval")
+	duration := flag.Duration("duration", 10*time.Second, "how long to run the synthetic market")
+	regimeGate := flag.Float64("regime-gate", 0, "with -returns, suppress buys while the HMM bear probability exceeds this (0 = off)")
+	regimeWindow := flag.Int("regime-window", 500, "leading bars used to fit the -regime-gate HMM; they are not replayed")
+	sizing := flag.String("sizing", "fraction", "backtest position sizing: fraction, voltarget or kelly (the last two need -returns)")
+	httpAddr := flag.String("http", "", "run the multi-symbol service with its HTTP control plane on this address")
+	symbols := flag.String("symbols", "AAA,BBB,CCC", "comma-separated symbols for -http")
+	spreadBps := flag.Float64("spread", 0, "replay backtest fills at synthetic quotes about this many bps wide (0 = at the price)")
+	maxPosition := flag.Float64("max-position", 0, "risk limit on the absolute position in units (0 = none)")
+	maxGross := flag.Float64("max-gross", 0, "risk limit on gross exposure (0 = none)")
+	maxDailyLoss := flag.Float64("max-daily-loss", 0, "stop adding risk after losing this much in a day (0 = none)")
+	maxOrders := flag.Int("max-orders-per-minute", 0, "risk limit on the order rate (0 = none)")
+	killFile := flag.String("kill-file", "", "reject every order while this file exists")
+	thresholdFlag := flag.Float64("threshold", 0, "signal threshold on the mean (default 100 for prices, 0 for -returns)")
+	confidenceFlag := flag.Float64("confidence", 0.95, "posterior probability needed to signal")
+	sweep := flag.String("sweep", "", "search signal parameters on the OHLCV files: grid or random")
+	sweepSpace := flag.String("sweep-space", "", `values searched per parameter, e.g. "kappa=0.1,1,10;confidence=0.9,0.99"`)
+	sweepFolds := flag.Int("sweep-folds", 4, "walk-forward folds for -sweep")
+	sweepSamples := flag.Int("sweep-samples", 50, "candidates drawn by -sweep random")
+	priorFit := flag.String("prior-fit", "", "fit the prior to history by empirical Bayes: moments or ml (default hand-picked)")
+	priorWindow := flag.Int("prior-window", 500, "bars, or synthetic ticks, of history used by -prior-fit")
+	priorBlock := flag.Int("prior-block", 25, "observations per block for -prior-fit")
+	paper := flag.Bool("paper", false, "trade the synthetic market through the paper broker instead of the backtester")
+	fdr := flag.String("fdr", "bayes", "multiple-testing correction across -http symbols: bayes, bonferroni or bh")
+	fdrLevel := flag.Float64("fdr-level", 0.05, "false discovery level for -fdr")
+	flag.Parse()
 
+	if *httpAddr != "" {
+		method, err := ParseCorrectionMethod(*fdr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		portfolio := NewPortfolioDecider(method, *fdrLevel)
+		if err := runControlPlane(*httpAddr, strings.Split(*symbols, ","), *tick, *seed, portfolio); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Weakly informative prior: mean around 100, E[sigma^2] = Beta/(Alpha-1) = 100
+	prior := NIGParams{Mu: 100, Kappa: 1, Alpha: 2, Beta: 100}
+	known := NewNormalKnownVarianceModel(100, 10, 10)
 	threshold := 100.0
-	confidence := 0.95
+	if *returns != "" {
+		// Mean return around zero with about 1% volatility; signal on a positive expected return
+		prior = NIGParams{Mu: 0, Kappa: 1, Alpha: 2, Beta: 1e-4}
+		known = NewNormalKnownVarianceModel(0, 0.01, 0.01)
+		threshold = 0
+		if *normalize {
+			// Normalized returns have unit variance
+			prior.Beta = 1
+			known = NewNormalKnownVarianceModel(0, 1, 1)
+		}
+	}
+	confidence := *confidenceFlag
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "threshold" {
+			threshold = *thresholdFlag
+		}
+	})
 
-	fmt.Println("Starting data source...")
-	go ds.Start()
+	if *sweep != "" {
+		cfg := SweepConfig{
+			Backtest:   BacktestConfig{InitialCash: 100000, Sizer: EquityFractionSizer{Fraction: 0.5}, CostBps: 1, SlippageBps: 2},
+			Returns:    *returns != "",
+			Normalize:  *normalize,
+			Forgetting: *forgetting,
+			Folds:      *sweepFolds,
+		}
+		if cfg.Returns {
+			var err error
+			if cfg.Kind, err = ParseReturnKind(*returns); err != nil {
+				log.Fatal(err)
+			}
+		}
+		space, err := ParseSweepSpace(*sweepSpace, DefaultSweepSpace(prior, threshold))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runSweep(*sweep, space, *sweepSamples, cfg, *seed, flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	time.Sleep(10 * time.Second) // Wait for some data updates
+	files := flag.Args()
+	var bars []Bar
+	var ds *DataSource
+	var err error
+	if len(files) > 0 {
+		if bars, err = LoadOHLCV(files...); err == nil && len(bars) == 0 {
+			err = fmt.Errorf("no bars in %s", strings.Join(files, ", "))
+		}
+	} else {
+		ds, err = newSyntheticMarket(*process, *tick, *seed)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	fmt.Printf("Final estimated mean: %.2f\n", estimator.mean)
-	fmt.Printf("Signal: %d\n", estimator.GenerateSignal(threshold, confidence))
-	fmt.Printf("Probability of mean > %.2f: %.2f\n", threshold, estimator.ProbabilityOfHigherMean(threshold))
+	if *priorFit != "" {
+		method, err := ParsePriorMethod(*priorFit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var history []float64
+		if ds != nil {
+			history = ds.Generate(*priorWindow) // The live run continues from here
+		} else {
+			if len(bars) < 2 {
+				log.Fatalf("-prior-fit needs history and bars to replay, got %d bars", len(bars))
+			}
+			n := min(*priorWindow, len(bars)-1)
+			for _, b := range bars[:n] {
+				history = append(history, b.Close)
+			}
+			bars = bars[n:] // Only replay what the prior has not seen
+		}
+		if *returns != "" {
+			kind, err := ParseReturnKind(*returns)
+			if err != nil {
+				log.Fatal(err)
+			}
+			rt := NewReturnsTransform(kind)
+			if *normalize {
+				rt = NewNormalizedReturnsTransform(kind, 0.94)
+			}
+			c := &collector{}
+			rt.Attach(c)
+			for _, price := range history {
+				rt.Update(price)
+			}
+			history = c.values
+		}
+		if prior, err = FitPrior(SplitBlocks(history, *priorBlock), method); err != nil {
+			log.Fatal(err)
+		}
+		variance := prior.Beta / (prior.Alpha - 1)
+		known = NewNormalKnownVarianceModel(prior.Mu, math.Sqrt(variance/prior.Kappa), math.Sqrt(variance))
+		fmt.Printf("Fitted prior to %d observations: mu=%.4g kappa=%.4g alpha=%.4g beta=%.4g\n",
+			len(history), prior.Mu, prior.Kappa, prior.Alpha, prior.Beta)
+	}
+
+	var estimator *BayesianEstimator
+	switch {
+	case *window > 0:
+		estimator = NewWindowedEstimator(prior, *window)
+	case *forgetting < 1:
+		estimator = NewDiscountedEstimator(prior, *forgetting)
+	default:
+		estimator = NewBayesianEstimator(prior)
+	}
+	if *seed != 0 {
+		estimator.SetRand(rand.New(rand.NewSource(*seed)))
+	}
+	if *snapshotPath != "" {
+		// A snapshot carries its own memory mode, which must match the flags
+		wantForgetting, wantWindow := estimator.Mode()
+		err := ReadSnapshot(*snapshotPath, estimator)
+		switch {
+		case err == nil:
+			if f, w := estimator.Mode(); f != wantForgetting || w != wantWindow {
+				log.Fatalf("snapshot %s has forgetting=%g window=%d, but the flags ask for forgetting=%g window=%d",
+					*snapshotPath, f, w, wantForgetting, wantWindow)
+			}
+			fmt.Printf("Restored estimator from %s (%d observations)\n", *snapshotPath, estimator.Count())
+		case !errors.Is(err, fs.ErrNotExist):
+			log.Fatal(err)
+		}
+	}
+
+	// Competing models on the same tick stream
+	robust := NewStudentTModel(prior, 4)
+
+	// Regime changes are expected every ~200 ticks
+	detector := NewChangePointDetector(prior, 200, 10, 0.5)
+	detector.Subscribe(func(s ChangePointState) {
+		if s.Changed {
+			fmt.Printf("Change point detected at tick %d (P=%.2f)\n", s.Tick, s.Probability)
+		}
+	})
+	if *resetOnChange {
+		detector.ResetOnChange(true, estimator)
+	}
+
+	// The detector may reset the estimator, so it sees each input right after it
+	var input Observer = Chain(estimator, detector, known, robust)
+	// Variance of raw returns, about 1% daily volatility a priori, forgetting over ~50 ticks
+	vol := NewVolatilityEstimator(3, 2e-4, 0.98)
+	if *returns != "" {
+		input = Chain(input, vol)
+	}
+	var kind ReturnKind
+	if *returns != "" {
+		var err error
+		if kind, err = ParseReturnKind(*returns); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var regimes *HMMRegimeModel
+	if *returns != "" && *regimeGate > 0 {
+		if *normalize {
+			// The regime emissions are in units of raw returns
+			log.Fatal("-regime-gate cannot be combined with -normalize")
+		}
+		regimes = NewHMMRegimeModel()
+		if len(bars) > 0 {
+			// Fit on the leading bars and only replay the rest, so the gate
+			// never uses parameters learned from the bars it trades
+			n := min(*regimeWindow, len(bars)-1)
+			if n < 3 {
+				log.Fatalf("-regime-gate needs history and bars to replay, got %d bars", len(bars))
+			}
+			closes := make([]float64, n)
+			for i, b := range bars[:n] {
+				closes[i] = b.Close
+			}
+			bars = bars[n:]
+			if ll, err := regimes.Fit(Returns(closes, kind), 100); err != nil {
+				log.Printf("regime fit: %v", err)
+			} else {
+				fmt.Printf("Fitted regimes to %d bars (log-likelihood %.1f)\n", n, ll)
+			}
+		}
+		estimator.SetGate(regimes.BearGate(*regimeGate))
+		input = Chain(regimes, input) // Filter the regime before the estimator's signal is read
+	}
+	if *returns != "" {
+		rt := NewReturnsTransform(kind)
+		if *normalize {
+			rt = NewNormalizedReturnsTransform(kind, 0.94)
+		}
+		rt.Attach(input)
+		input = rt
+	}
+
+	var audit *AuditLog
+	if *auditPath != "" {
+		var err error
+		if audit, err = OpenAuditLog(*auditPath); err != nil {
+			log.Fatal(err)
+		}
+		defer audit.Close()
+	}
+	signal := func() int {
+		d := estimator.GenerateSignal(threshold, confidence)
+		if audit != nil {
+			if err := audit.Record(d); err != nil {
+				log.Printf("audit: %v", err)
+			}
+		}
+		return d.Signal
+	}
+
+	var sizer Sizer = EquityFractionSizer{Fraction: 0.5}
+	switch {
+	case *sizing == "fraction":
+	case *returns == "" || *normalize:
+		log.Fatalf("-sizing %s needs raw -returns", *sizing)
+	case *sizing == "voltarget":
+		sizer = VolTargetSizer{TargetVol: 0.005, Vol: vol, MaxLeverage: 1}
+	case *sizing == "kelly":
+		sizer = KellySizer{Mean: estimator, Vol: vol, Fraction: 0.5, MaxLeverage: 1}
+	default:
+		log.Fatalf("unknown sizing %q", *sizing)
+	}
+
+	// Every backtest order goes through the risk manager
+	risk := NewRiskManager(RiskLimits{
+		MaxPosition:        *maxPosition,
+		MaxGrossExposure:   *maxGross,
+		MaxDailyLoss:       *maxDailyLoss,
+		MaxOrdersPerMinute: *maxOrders,
+	})
+	if audit != nil {
+		risk.OnReject(func(r Rejection) {
+			if err := audit.RecordRejection(r); err != nil {
+				log.Printf("audit: %v", err)
+			}
+		})
+	}
+	if *killFile != "" {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go risk.WatchKillFile(ctx, *killFile, time.Second)
+	}
+	newQuotes := func(spreadBps, depth float64) *QuoteSynthesizer {
+		quoteSeed := *seed
+		if quoteSeed == 0 {
+			quoteSeed = time.Now().UnixNano()
+		}
+		// Spreads wander around their mean with a half-life of about 7 ticks
+		return NewQuoteSynthesizer(rand.New(rand.NewSource(quoteSeed)), spreadBps, 0.9, 0.25, depth)
+	}
+	newBacktester := func(symbol string) *Backtester {
+		return NewBacktester(BacktestConfig{
+			InitialCash: 100000,
+			Sizer:       sizer,
+			CostBps:     1,
+			SlippageBps: 2,
+			Risk:        risk,
+			Symbol:      symbol,
+		}, signal)
+	}
+
+	now := time.Now
+	if len(files) > 0 {
+		rs := NewReplaySource(bars, *speed)
+		bt := newBacktester(strings.TrimSuffix(filepath.Base(files[0]), filepath.Ext(files[0])))
+		// Risk limits, quotes and decisions run on bar time, however fast the replay
+		clock := NewBarClock(bars)
+		now = clock.Now
+		risk.SetClock(clock.Now)
+		estimator.SetClock(clock.Now)
+		var spreads *SpreadEstimator
+		if *spreadBps > 0 {
+			qs := newQuotes(*spreadBps, 1000)
+			qs.SetClock(clock.Now)
+			spreads = NewSpreadEstimator(nil, NIGParams{Mu: math.Log(*spreadBps / 1e4), Kappa: 1, Alpha: 2, Beta: 0.1}, 0.99)
+			qs.AttachMid(input)
+			qs.Attach(spreads)
+			qs.Attach(bt) // Fills at the bid or ask
+			rs.AttachBlocking(Chain(clock, qs))
+		} else {
+			rs.AttachBlocking(Chain(clock, input, bt)) // The backtester reads the estimator's signal
+		}
+
+		fmt.Printf("Replaying %d bars...\n", len(bars))
+		rs.Run(context.Background())
+		fmt.Printf("Backtest: %v\n", bt.Report())
+		fmt.Printf("Risk: %v\n", risk.Status())
+		if spreads != nil {
+			fmt.Printf("Spread: expected %.1f bps, persistence %.2f, depth %.0f\n",
+				1e4*spreads.ExpectedSpread(), spreads.Persistence(), spreads.Depth())
+		}
+
+		if *returns != "" && !*normalize {
+			const horizon, units = 10, 100
+			last := bars[len(bars)-1].Close
+			paths := CompoundPaths(last, kind, estimator.SimulatePaths(horizon, 10000))
+			risk := PredictiveRisk(last, units, paths, 0.99)
+			fmt.Printf("%d-bar 99%% VaR of %d units: %.2f (ES %.2f)\n", horizon, units, risk.VaR, risk.ES)
+		}
+	} else {
+		var report func()
+		if *paper {
+			spread := *spreadBps
+			if spread == 0 {
+				spread = 5
+			}
+			// Thin books: each quote fills at most a quarter of about 200 units
+			qs := newQuotes(spread, 200)
+			broker := NewPaperBroker(PaperBrokerConfig{Participation: 0.25, Risk: risk})
+			strategy := NewSignalStrategy(broker, *process, signal, sizer, 100000, false)
+			var last Quote
+			qs.AttachMid(input)
+			qs.Attach(broker.Feed(*process)) // Fill earlier orders before the strategy trades
+			qs.Attach(strategy)
+			qs.Attach(QuoteObserverFunc(func(q Quote) { last = q }))
+			ds.AttachBlocking(qs)
+			report = func() {
+				fmt.Printf("Paper: %v equity=%.2f\n", strategy, strategy.Equity(last.Mid()))
+			}
+		} else {
+			bt := newBacktester(*process)
+			ds.AttachBlocking(Chain(input, bt)) // The backtester reads the estimator's signal
+			report = func() {
+				fmt.Printf("Backtest: %v\n", bt.Report())
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), *duration)
+		defer cancel()
+		if *snapshotPath != "" {
+			go NewSnapshotter(*snapshotPath, 5*time.Second, estimator).Run(ctx)
+		}
+
+		fmt.Println("Starting data source...")
+		ds.Start(ctx) // Runs until the timeout
+		ds.Drain()
+		report()
+		fmt.Printf("Risk: %v\n", risk.Status())
+	}
+
+	if *snapshotPath != "" {
+		if err := WriteSnapshot(*snapshotPath, estimator); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	post := estimator.Params()
+	fmt.Printf("Observations: %d\n", estimator.Count())
+	fmt.Printf("Posterior: mu=%.4g kappa=%.4g alpha=%.4g beta=%.4g\n", post.Mu, post.Kappa, post.Alpha, post.Beta)
+	fmt.Printf("Decision: %v\n", estimator.GenerateSignal(threshold, confidence))
+	fmt.Printf("Probability of mean > %.4g: %.2f\n", threshold, estimator.ProbabilityOfHigherMean(threshold))
+
+	if regimes != nil {
+		probs := regimes.Probabilities()
+		fmt.Printf("Regime: %v (bull=%.2f bear=%.2f sideways=%.2f)\n", regimes.MostLikely(), probs[Bull], probs[Bear], probs[Sideways])
+	}
+
+	models := []struct {
+		name  string
+		model Model
+	}{
+		{"normal-known-variance", known},
+		{"normal-inverse-gamma", estimator},
+		{"student-t", robust},
+	}
+	for _, m := range models {
+		lo, hi := m.model.CredibleInterval(0.95)
+		fmt.Printf("%-22s P(mean > %.4g)=%.3f 95%% CI=[%.4g, %.4g] signal=%d\n",
+			m.name, threshold, m.model.ProbabilityAbove(threshold), lo, hi, decide(m.model, threshold, confidence, now()).Signal)
+	}
+}
+
+// runSweep walk-forward tests the grid of space, or samples random candidates
+// from it, on the closes of the OHLCV files and prints the out-of-sample
+// results per fold.
+func runSweep(mode string, space SweepSpace, samples int, cfg SweepConfig, seed int64, files []string) error {
+	if len(files) == 0 {
+		return errors.New("-sweep needs OHLCV files")
+	}
+	bars, err := LoadOHLCV(files...)
+	if err != nil {
+		return err
+	}
+	prices := make([]float64, len(bars))
+	for i, b := range bars {
+		prices[i] = b.Close
+	}
+
+	var candidates []SweepParams
+	switch mode {
+	case "grid":
+		candidates = space.Grid()
+	case "random":
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		candidates = space.Random(rand.New(rand.NewSource(seed)), samples)
+	default:
+		return fmt.Errorf("unknown sweep %q", mode)
+	}
+
+	fmt.Printf("Sweeping %d candidates over %d bars in %d walk-forward folds...\n", len(candidates), len(prices), cfg.Folds)
+	results, err := WalkForward(prices, candidates, cfg)
+	if err != nil {
+		return err
+	}
+	for _, r := range results {
+		fmt.Println(r)
+	}
+	fmt.Println(Summarize(results))
+	return nil
+}
+
+// newSyntheticMarket builds the named synthetic market. A zero seed is
+// replaced by the clock.
+func newSyntheticMarket(process string, tick time.Duration, seed int64) (*DataSource, error) {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	gbm := GBMProcess{Drift: 0.0002, Volatility: 0.01}
+
+	var p PriceProcess
+	switch process {
+	case "noise":
+		p = NoiseProcess{Mean: 105, StdDev: 10}
+	case "gbm":
+		p = gbm
+	case "jump":
+		p = JumpDiffusionProcess{GBMProcess: gbm, JumpRate: 0.01, JumpMean: -0.03, JumpStd: 0.05}
+	case "regime":
+		bear := GBMProcess{Drift: -0.001, Volatility: 0.02}
+		rs, err := NewRegimeSwitchingProcess([]PriceProcess{gbm, bear}, [][]float64{{0.99, 0.01}, {0.03, 0.97}})
+		if err != nil {
+			return nil, err
+		}
+		p = rs
+	default:
+		return nil, fmt.Errorf("unknown market process %q", process)
+	}
+	return NewSyntheticSource(tick, rng, p, 105), nil
 }
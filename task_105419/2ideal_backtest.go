@@ -0,0 +1,243 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// Sizer converts a signal into a target position in units of the instrument.
+type Sizer interface {
+	Target(signal int, price, equity float64) float64
+}
+
+// FixedSizer trades a constant number of units per signal.
+type FixedSizer struct {
+	Units float64
+}
+
+// Target implements Sizer.
+func (s FixedSizer) Target(signal int, price, equity float64) float64 {
+	return float64(signal) * s.Units
+}
+
+// EquityFractionSizer commits a fixed fraction of current equity per signal.
+type EquityFractionSizer struct {
+	Fraction float64
+}
+
+// Target implements Sizer.
+func (s EquityFractionSizer) Target(signal int, price, equity float64) float64 {
+	return float64(signal) * s.Fraction * equity / price
+}
+
+// BacktestConfig holds the execution assumptions of a backtest.
+type BacktestConfig struct {
+	InitialCash    float64
+	Sizer          Sizer
+	CostBps        float64      // Transaction cost on traded notional, in basis points
+	SlippageBps    float64      // Adverse price move on each fill, in basis points
+	AllowShort     bool         // Sell signals go short instead of flat
+	PeriodsPerYear float64      // Used to annualize the Sharpe ratio
+	Risk           *RiskManager // Optional pre-trade checks on every order
+	Symbol         string       // Symbol of the orders sent to Risk
+}
+
+// BacktestReport summarizes a backtest run.
+type BacktestReport struct {
+	Equity      []float64
+	TotalReturn float64
+	Sharpe      float64
+	MaxDrawdown float64
+	HitRate     float64 // Fraction of closed trades with positive P&L
+	Turnover    float64 // Traded notional divided by average equity
+	SpreadCost  float64 // Paid by crossing the spread, relative to filling at the mid
+	Trades      int
+}
+
+// String formats the report's summary statistics.
+func (r BacktestReport) String() string {
+	s := fmt.Sprintf("return=%.2f%% sharpe=%.2f maxDD=%.2f%% hit=%.2f%% turnover=%.2f trades=%d",
+		100*r.TotalReturn, r.Sharpe, 100*r.MaxDrawdown, 100*r.HitRate, r.Turnover, r.Trades)
+	if r.SpreadCost > 0 {
+		s += fmt.Sprintf(" spread=%.2f", r.SpreadCost)
+	}
+	return s
+}
+
+// Backtester turns a signal stream into positions and P&L. Signals observed
+// on one price are filled on the next, so there is no look-ahead. Fed with
+// quotes, it buys at the ask, sells at the bid and marks to the mid.
+type Backtester struct {
+	cfg      BacktestConfig
+	signal   func() int
+	cash     float64
+	position float64
+	avgEntry float64
+	pending  int
+	hasPrice bool
+	traded   float64
+	spread   float64
+	wins     int
+	closed   int
+	trades   int
+	equity   []float64
+	mux      sync.Mutex
+}
+
+// NewBacktester creates a backtester. When used as an Observer it polls signal
+// after every price, so it should be attached after the estimator it reads.
+func NewBacktester(cfg BacktestConfig, signal func() int) *Backtester {
+	if cfg.Sizer == nil {
+		cfg.Sizer = FixedSizer{Units: 1}
+	}
+	if cfg.PeriodsPerYear == 0 {
+		cfg.PeriodsPerYear = 252
+	}
+	return &Backtester{
+		cfg:    cfg,
+		signal: signal,
+		cash:   cfg.InitialCash,
+	}
+}
+
+// Update implements Observer.
+func (b *Backtester) Update(price float64) {
+	b.Step(price, b.signal())
+}
+
+// OnQuote implements QuoteObserver.
+func (b *Backtester) OnQuote(q Quote) {
+	b.StepQuote(q, b.signal())
+}
+
+// Step fills the previously observed signal at price, marks the position to
+// market and queues signal for the next step.
+func (b *Backtester) Step(price float64, signal int) {
+	b.StepQuote(Quote{Bid: price, Ask: price}, signal)
+}
+
+// StepQuote is Step for a quote: the previous signal is filled at the touch
+// and the position marked at the mid.
+func (b *Backtester) StepQuote(q Quote, signal int) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.hasPrice {
+		b.rebalance(q)
+	}
+	if b.cfg.Risk != nil {
+		b.cfg.Risk.Mark(b.cfg.Symbol, q.Mid())
+	}
+	b.hasPrice = true
+	b.pending = signal
+	b.equity = append(b.equity, b.cash+b.position*q.Mid())
+}
+
+// rebalance moves the position to the sizer's target for the pending signal.
+func (b *Backtester) rebalance(q Quote) {
+	price := q.Mid()
+	signal := b.pending
+	if signal < 0 && !b.cfg.AllowShort {
+		signal = 0
+	}
+	if b.pending == 0 || float64(signal)*b.position > 0 {
+		return // Hold, or already positioned in the signal's direction
+	}
+	target := b.cfg.Sizer.Target(signal, price, b.cash+b.position*price)
+	qty := target - b.position
+	if qty == 0 {
+		return
+	}
+
+	touch := q.Ask
+	if qty < 0 {
+		touch = q.Bid
+	}
+	slip := touch * b.cfg.SlippageBps / 1e4
+	fill := touch + math.Copysign(slip, qty)
+	if b.cfg.Risk != nil {
+		order := Order{Time: q.Time, Symbol: b.cfg.Symbol, Quantity: qty, Price: fill}
+		if b.cfg.Risk.Check(order) != nil {
+			return // Recorded by the risk manager
+		}
+	}
+	notional := math.Abs(qty) * fill
+	cost := notional * b.cfg.CostBps / 1e4
+	b.cash -= qty*fill + cost
+	if b.cfg.Risk != nil {
+		b.cfg.Risk.Fill(b.cfg.Symbol, qty, fill, cost)
+	}
+	b.traded += notional
+	b.spread += math.Abs(qty) * math.Abs(touch-price)
+	b.trades++
+
+	// Realize P&L on the part of the order that reduces the position
+	if b.position != 0 && math.Signbit(qty) != math.Signbit(b.position) {
+		closing := math.Min(math.Abs(qty), math.Abs(b.position))
+		pnl := closing * (fill - b.avgEntry) * math.Copysign(1, b.position)
+		b.closed++
+		if pnl > 0 {
+			b.wins++
+		}
+		if math.Abs(qty) > math.Abs(b.position) {
+			b.avgEntry = fill // Flipped through zero
+		}
+	} else {
+		b.avgEntry = (b.avgEntry*math.Abs(b.position) + fill*math.Abs(qty)) / math.Abs(target)
+	}
+	b.position = target
+}
+
+// Report computes performance statistics over the equity curve so far.
+func (b *Backtester) Report() BacktestReport {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	r := BacktestReport{
+		Equity:     append([]float64(nil), b.equity...),
+		Trades:     b.trades,
+		SpreadCost: b.spread,
+	}
+	if len(b.equity) == 0 {
+		return r
+	}
+	if b.cfg.InitialCash != 0 {
+		r.TotalReturn = b.equity[len(b.equity)-1]/b.cfg.InitialCash - 1
+	}
+	if b.closed > 0 {
+		r.HitRate = float64(b.wins) / float64(b.closed)
+	}
+
+	var sum, sumSq, peak, eqSum float64
+	n := 0
+	for i, eq := range b.equity {
+		eqSum += eq
+		if eq > peak {
+			peak = eq
+		}
+		if peak > 0 {
+			r.MaxDrawdown = math.Max(r.MaxDrawdown, (peak-eq)/peak)
+		}
+		if i > 0 && b.equity[i-1] != 0 {
+			ret := eq/b.equity[i-1] - 1
+			sum += ret
+			sumSq += ret * ret
+			n++
+		}
+	}
+	if n > 1 {
+		mean := sum / float64(n)
+		sd := math.Sqrt((sumSq - float64(n)*mean*mean) / float64(n-1))
+		if sd > 0 {
+			r.Sharpe = mean / sd * math.Sqrt(b.cfg.PeriodsPerYear)
+		}
+	}
+	if avg := eqSum / float64(len(b.equity)); avg != 0 {
+		r.Turnover = b.traded / avg
+	}
+	return r
+}
@@ -0,0 +1,98 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// closeTo reports whether got is within 1e-9 of want.
+func closeTo(got, want float64) bool {
+	return math.Abs(got-want) <= 1e-9*math.Max(1, math.Abs(want))
+}
+
+func TestBacktesterFillsAndPnL(t *testing.T) {
+	bt := NewBacktester(BacktestConfig{InitialCash: 1000, Sizer: FixedSizer{Units: 10}}, nil)
+	bt.Step(100, 1)  // Buy signal, filled on the next price
+	bt.Step(110, 0)  // Bought 10 at 110
+	bt.Step(120, -1) // Hold, then exit
+	bt.Step(115, 0)  // Sold 10 at 115
+
+	r := bt.Report()
+	if want := []float64{1000, 1000, 1100, 1050}; !slices.Equal(r.Equity, want) {
+		t.Errorf("Equity = %v, want %v", r.Equity, want)
+	}
+	if r.Trades != 2 {
+		t.Errorf("Trades = %d, want 2", r.Trades)
+	}
+	if !closeTo(r.TotalReturn, 0.05) {
+		t.Errorf("TotalReturn = %v, want 0.05", r.TotalReturn)
+	}
+	if r.HitRate != 1 {
+		t.Errorf("HitRate = %v, want 1", r.HitRate)
+	}
+	if want := 50.0 / 1100; !closeTo(r.MaxDrawdown, want) {
+		t.Errorf("MaxDrawdown = %v, want %v", r.MaxDrawdown, want)
+	}
+}
+
+func TestBacktesterCosts(t *testing.T) {
+	bt := NewBacktester(BacktestConfig{
+		InitialCash: 1000,
+		Sizer:       FixedSizer{Units: 10},
+		CostBps:     10,
+		SlippageBps: 10,
+	}, nil)
+	bt.Step(100, 1)
+	bt.Step(100, 0)
+
+	fill := 100 * 1.001 // Slipped against the buyer
+	want := 1000 - 10*fill - 10*fill*0.001 + 10*100
+	if got := bt.Report().Equity[1]; !closeTo(got, want) {
+		t.Errorf("equity after costs = %v, want %v", got, want)
+	}
+}
+
+func TestBacktesterQuotes(t *testing.T) {
+	bt := NewBacktester(BacktestConfig{InitialCash: 1000, Sizer: FixedSizer{Units: 10}}, nil)
+	bt.StepQuote(Quote{Bid: 99, Ask: 101}, 1)
+	bt.StepQuote(Quote{Bid: 99, Ask: 101}, -1) // Bought at the ask
+	bt.StepQuote(Quote{Bid: 99, Ask: 101}, 0)  // Sold at the bid
+
+	r := bt.Report()
+	if want := []float64{1000, 990, 980}; !slices.Equal(r.Equity, want) {
+		t.Errorf("Equity = %v, want %v", r.Equity, want)
+	}
+	if r.SpreadCost != 20 {
+		t.Errorf("SpreadCost = %v, want 20", r.SpreadCost)
+	}
+	if r.HitRate != 0 {
+		t.Errorf("HitRate = %v, want 0", r.HitRate)
+	}
+}
+
+func TestBacktesterShortAndRisk(t *testing.T) {
+	risk := NewRiskManager(RiskLimits{MaxPosition: 10})
+	bt := NewBacktester(BacktestConfig{
+		InitialCash: 1000,
+		Sizer:       FixedSizer{Units: 10},
+		AllowShort:  true,
+		Risk:        risk,
+		Symbol:      "X",
+	}, nil)
+	bt.Step(100, -1)
+	bt.Step(100, 1) // Short 10
+	bt.Step(90, 0)  // Flip to long 10
+	bt.Step(95, 0)
+
+	r := bt.Report()
+	if want := []float64{1000, 1000, 1100, 1150}; !slices.Equal(r.Equity, want) {
+		t.Errorf("Equity = %v, want %v", r.Equity, want)
+	}
+	if got := risk.Status().Positions["X"]; got != 10 {
+		t.Errorf("risk position = %v, want 10", got)
+	}
+}
@@ -0,0 +1,461 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Side is the direction of an order.
+type Side int
+
+const (
+	Buy Side = iota
+	Sell
+)
+
+// String implements fmt.Stringer.
+func (s Side) String() string {
+	if s == Sell {
+		return "sell"
+	}
+	return "buy"
+}
+
+// sign returns +1 for buys and -1 for sells.
+func (s Side) sign() float64 {
+	if s == Sell {
+		return -1
+	}
+	return 1
+}
+
+// OrderType is how an order may be executed.
+type OrderType int
+
+const (
+	MarketOrder OrderType = iota // At the touch, whatever the price
+	LimitOrder                   // Only at LimitPrice or better
+)
+
+// OrderStatus is the state of an order, as in FIX OrdStatus.
+type OrderStatus int
+
+const (
+	OrderNew OrderStatus = iota
+	OrderPartiallyFilled
+	OrderFilled
+	OrderCanceled
+	OrderRejected
+)
+
+// String implements fmt.Stringer.
+func (s OrderStatus) String() string {
+	switch s {
+	case OrderNew:
+		return "new"
+	case OrderPartiallyFilled:
+		return "partially filled"
+	case OrderFilled:
+		return "filled"
+	case OrderCanceled:
+		return "canceled"
+	case OrderRejected:
+		return "rejected"
+	}
+	return fmt.Sprintf("OrderStatus(%d)", int(s))
+}
+
+// terminal reports whether no more fills can happen.
+func (s OrderStatus) terminal() bool {
+	return s == OrderFilled || s == OrderCanceled || s == OrderRejected
+}
+
+// OrderRequest is a new order, as in FIX NewOrderSingle.
+type OrderRequest struct {
+	ClientID   string // Caller's reference, echoed in reports
+	Symbol     string
+	Side       Side
+	Type       OrderType
+	Quantity   float64
+	LimitPrice float64 // Only for LimitOrder
+}
+
+// ExecutionReport describes a change to an order, as in FIX ExecutionReport.
+type ExecutionReport struct {
+	Time      time.Time
+	OrderID   string
+	ClientID  string
+	Symbol    string
+	Side      Side
+	Status    OrderStatus
+	LastQty   float64 // Filled by this report
+	LastPrice float64
+	CumQty    float64 // Filled so far
+	LeavesQty float64 // Still working
+	AvgPrice  float64 // Average price of CumQty
+	Text      string  // Why an order was rejected
+}
+
+// Broker is the order interface strategies trade through. Reports for every
+// order, including rejections, are delivered to the subscribers.
+type Broker interface {
+	PlaceOrder(req OrderRequest) (orderID string, err error)
+	CancelOrder(orderID string) error
+	Subscribe(fn func(ExecutionReport))
+}
+
+// PaperBrokerConfig holds the execution assumptions of a PaperBroker.
+type PaperBrokerConfig struct {
+	Participation float64      // Fraction of the displayed size one quote can fill (default 1)
+	Risk          *RiskManager // Optional pre-trade checks on every order
+}
+
+// paperOrder is a working or finished order of a PaperBroker.
+type paperOrder struct {
+	req    OrderRequest
+	report ExecutionReport // Latest state
+}
+
+// PaperBroker is a local Broker simulator. Orders are matched against the
+// next quotes of their symbol, never the one they were placed on: buys lift
+// the ask and sells hit the bid, limited by the displayed size, so large
+// orders fill in parts over several quotes. Orders share each quote's
+// liquidity in the order they were placed.
+type PaperBroker struct {
+	cfg       PaperBrokerConfig
+	seq       int
+	orders    map[string]*paperOrder
+	working   []string // IDs of working orders, oldest first
+	quotes    map[string]Quote
+	listeners []func(ExecutionReport)
+	mux       sync.Mutex
+}
+
+// NewPaperBroker creates a broker simulator.
+func NewPaperBroker(cfg PaperBrokerConfig) *PaperBroker {
+	if cfg.Participation == 0 {
+		cfg.Participation = 1
+	}
+	return &PaperBroker{
+		cfg:    cfg,
+		orders: make(map[string]*paperOrder),
+		quotes: make(map[string]Quote),
+	}
+}
+
+// Subscribe implements Broker.
+func (b *PaperBroker) Subscribe(fn func(ExecutionReport)) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.listeners = append(b.listeners, fn)
+}
+
+// PlaceOrder implements Broker. The order is acknowledged, or rejected when
+// it is malformed or fails the risk checks. The risk check runs without the
+// broker's lock, so its listeners may call back into the broker.
+func (b *PaperBroker) PlaceOrder(req OrderRequest) (string, error) {
+	b.mux.Lock()
+	b.seq++
+	id := fmt.Sprintf("P%d", b.seq)
+	now := b.quotes[req.Symbol].Time // Market time of the latest quote
+	if now.IsZero() {
+		now = time.Now()
+	}
+	report := ExecutionReport{
+		Time:      now,
+		OrderID:   id,
+		ClientID:  req.ClientID,
+		Symbol:    req.Symbol,
+		Side:      req.Side,
+		Status:    OrderNew,
+		LeavesQty: req.Quantity,
+	}
+	err := b.validate(req)
+	price := b.expectedPrice(req)
+	b.mux.Unlock()
+
+	if err == nil && b.cfg.Risk != nil {
+		err = b.cfg.Risk.Check(Order{
+			Time:     report.Time,
+			Symbol:   req.Symbol,
+			Quantity: req.Side.sign() * req.Quantity,
+			Price:    price,
+		})
+	}
+
+	b.mux.Lock()
+	if err != nil {
+		report.Status, report.LeavesQty, report.Text = OrderRejected, 0, err.Error()
+	} else {
+		b.working = append(b.working, id)
+	}
+	b.orders[id] = &paperOrder{req: req, report: report}
+	listeners := slices.Clone(b.listeners)
+	b.mux.Unlock()
+
+	for _, fn := range listeners {
+		fn(report)
+	}
+	return id, err
+}
+
+// validate rejects malformed orders.
+func (b *PaperBroker) validate(req OrderRequest) error {
+	switch {
+	case req.Symbol == "":
+		return errors.New("missing symbol")
+	case !(req.Quantity > 0):
+		return fmt.Errorf("quantity %v must be positive", req.Quantity)
+	case req.Type == LimitOrder && !(req.LimitPrice > 0):
+		return fmt.Errorf("limit price %v must be positive", req.LimitPrice)
+	}
+	return nil
+}
+
+// expectedPrice is the price a new order is expected to fill at.
+func (b *PaperBroker) expectedPrice(req OrderRequest) float64 {
+	if req.Type == LimitOrder {
+		return req.LimitPrice
+	}
+	q := b.quotes[req.Symbol]
+	if req.Side == Sell {
+		return q.Bid
+	}
+	return q.Ask
+}
+
+// CancelOrder implements Broker. Fills that already happened stand.
+func (b *PaperBroker) CancelOrder(orderID string) error {
+	b.mux.Lock()
+	o, ok := b.orders[orderID]
+	if !ok {
+		b.mux.Unlock()
+		return fmt.Errorf("unknown order %s", orderID)
+	}
+	if o.report.Status.terminal() {
+		b.mux.Unlock()
+		return fmt.Errorf("order %s is %v", orderID, o.report.Status)
+	}
+	b.working = slices.DeleteFunc(b.working, func(id string) bool { return id == orderID })
+	o.report.Time = time.Now()
+	o.report.Status = OrderCanceled
+	o.report.LastQty, o.report.LastPrice, o.report.LeavesQty = 0, 0, 0
+	report := o.report
+	listeners := slices.Clone(b.listeners)
+	b.mux.Unlock()
+
+	for _, fn := range listeners {
+		fn(report)
+	}
+	return nil
+}
+
+// Order returns the latest report of an order, as in FIX OrderStatusRequest.
+func (b *PaperBroker) Order(orderID string) (ExecutionReport, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	o, ok := b.orders[orderID]
+	if !ok {
+		return ExecutionReport{}, false
+	}
+	return o.report, true
+}
+
+// Feed returns the QuoteObserver that drives matching for symbol.
+func (b *PaperBroker) Feed(symbol string) QuoteObserver {
+	return QuoteObserverFunc(func(q Quote) { b.match(symbol, q) })
+}
+
+// match fills the working orders of symbol against q.
+func (b *PaperBroker) match(symbol string, q Quote) {
+	b.mux.Lock()
+	if b.cfg.Risk != nil {
+		b.cfg.Risk.Mark(symbol, q.Mid())
+	}
+	bidLeft := q.BidSize * b.cfg.Participation
+	askLeft := q.AskSize * b.cfg.Participation
+	now := q.Time
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	var reports []ExecutionReport
+	for _, id := range b.working {
+		o := b.orders[id]
+		if o.req.Symbol != symbol {
+			continue
+		}
+		price, left := q.Ask, &askLeft
+		if o.req.Side == Sell {
+			price, left = q.Bid, &bidLeft
+		}
+		if o.req.Type == LimitOrder && (price-o.req.LimitPrice)*o.req.Side.sign() > 0 {
+			continue // Touch is worse than the limit
+		}
+		qty := math.Min(o.report.LeavesQty, *left)
+		if !(qty > 0) {
+			continue
+		}
+		*left -= qty
+
+		r := &o.report
+		r.Time = now
+		r.LastQty, r.LastPrice = qty, price
+		r.AvgPrice = (r.AvgPrice*r.CumQty + price*qty) / (r.CumQty + qty)
+		r.CumQty += qty
+		r.LeavesQty -= qty
+		r.Status = OrderPartiallyFilled
+		if r.LeavesQty <= 1e-9*o.req.Quantity {
+			r.LeavesQty = 0
+			r.Status = OrderFilled
+		}
+		if b.cfg.Risk != nil {
+			b.cfg.Risk.Fill(symbol, o.req.Side.sign()*qty, price, 0)
+		}
+		reports = append(reports, *r)
+	}
+	b.quotes[symbol] = q
+	b.working = slices.DeleteFunc(b.working, func(id string) bool {
+		return b.orders[id].report.Status.terminal()
+	})
+	listeners := slices.Clone(b.listeners)
+	b.mux.Unlock()
+
+	for _, r := range reports {
+		for _, fn := range listeners {
+			fn(r)
+		}
+	}
+}
+
+// SignalStrategy trades one symbol through a Broker, moving its position to
+// the sizer's target for the latest signal, with the Backtester's rules: 0
+// holds, and sell signals go flat unless shorting is allowed. It only knows
+// the Broker interface, so a real broker adapter can replace the simulator.
+type SignalStrategy struct {
+	broker     Broker
+	symbol     string
+	signal     func() int
+	sizer      Sizer
+	allowShort bool
+	cash       float64
+	position   float64
+	working    map[string]float64 // Signed quantity still working, by order ID
+	fills      int
+	rejected   int
+	mux        sync.Mutex
+}
+
+// NewSignalStrategy creates a strategy trading symbol on broker with cash.
+// When used as a QuoteObserver it polls signal on every quote, so it should be
+// attached after the estimator it reads and after the broker's feed.
+func NewSignalStrategy(broker Broker, symbol string, signal func() int, sizer Sizer, cash float64, allowShort bool) *SignalStrategy {
+	s := &SignalStrategy{
+		broker:     broker,
+		symbol:     symbol,
+		signal:     signal,
+		sizer:      sizer,
+		allowShort: allowShort,
+		cash:       cash,
+		working:    make(map[string]float64),
+	}
+	broker.Subscribe(s.onReport)
+	return s
+}
+
+// OnQuote implements QuoteObserver.
+func (s *SignalStrategy) OnQuote(q Quote) {
+	signal := s.signal()
+	target := 0.0
+	if signal < 0 && !s.allowShort {
+		signal = 0
+	} else if signal == 0 {
+		return // Hold
+	}
+
+	mid := q.Mid()
+	s.mux.Lock()
+	pending := 0.0
+	var stale []string
+	for id, qty := range s.working {
+		pending += qty
+		stale = append(stale, id)
+	}
+	exposure := s.position + pending
+	if float64(signal)*exposure > 0 || signal == 0 && exposure == 0 {
+		s.mux.Unlock()
+		return // Already positioned, or working orders, in the signal's direction
+	}
+	if signal != 0 {
+		target = s.sizer.Target(signal, mid, s.cash+s.position*mid)
+	}
+	s.mux.Unlock()
+
+	// Replace working orders with one for the whole difference
+	for _, id := range stale {
+		s.broker.CancelOrder(id)
+	}
+	s.mux.Lock()
+	qty := target - s.position
+	s.mux.Unlock()
+	if qty == 0 {
+		return
+	}
+	side := Buy
+	if qty < 0 {
+		side = Sell
+	}
+	s.broker.PlaceOrder(OrderRequest{Symbol: s.symbol, Side: side, Type: MarketOrder, Quantity: math.Abs(qty)})
+}
+
+// onReport tracks fills and working orders of the strategy's symbol.
+func (s *SignalStrategy) onReport(r ExecutionReport) {
+	if r.Symbol != s.symbol {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	sign := r.Side.sign()
+	if r.LastQty > 0 {
+		s.position += sign * r.LastQty
+		s.cash -= sign * r.LastQty * r.LastPrice
+		s.fills++
+	}
+	if r.Status == OrderRejected {
+		s.rejected++
+	}
+	if r.Status.terminal() {
+		delete(s.working, r.OrderID)
+	} else {
+		s.working[r.OrderID] = sign * r.LeavesQty
+	}
+}
+
+// Position returns the units held.
+func (s *SignalStrategy) Position() float64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.position
+}
+
+// Equity returns cash plus the position valued at price.
+func (s *SignalStrategy) Equity(price float64) float64 {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.cash + s.position*price
+}
+
+// String summarizes the strategy's activity.
+func (s *SignalStrategy) String() string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return fmt.Sprintf("position=%.2f cash=%.2f fills=%d rejected=%d working=%d",
+		s.position, s.cash, s.fills, s.rejected, len(s.working))
+}
@@ -0,0 +1,181 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// reportLog collects execution reports.
+type reportLog struct {
+	reports []ExecutionReport
+	mux     sync.Mutex
+}
+
+func (l *reportLog) add(r ExecutionReport) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.reports = append(l.reports, r)
+}
+
+// of returns the reports of one order.
+func (l *reportLog) of(orderID string) []ExecutionReport {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	var out []ExecutionReport
+	for _, r := range l.reports {
+		if r.OrderID == orderID {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// newTestBroker returns a broker whose reports are logged.
+func newTestBroker(cfg PaperBrokerConfig) (*PaperBroker, *reportLog) {
+	b := NewPaperBroker(cfg)
+	log := &reportLog{}
+	b.Subscribe(log.add)
+	return b, log
+}
+
+func TestPaperBrokerPartialFills(t *testing.T) {
+	b, log := newTestBroker(PaperBrokerConfig{Participation: 0.5})
+	feed := b.Feed("X")
+	quote := Quote{Bid: 99, Ask: 101, BidSize: 100, AskSize: 100}
+	feed.OnQuote(quote)
+
+	id, err := b.PlaceOrder(OrderRequest{ClientID: "c1", Symbol: "X", Side: Buy, Quantity: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed.OnQuote(quote)                                                 // 50 at 101
+	feed.OnQuote(Quote{Bid: 100, Ask: 102, BidSize: 100, AskSize: 100}) // 50 at 102
+	feed.OnQuote(Quote{Bid: 100, Ask: 103, BidSize: 100, AskSize: 100}) // 20 at 103
+	feed.OnQuote(Quote{Bid: 100, Ask: 103, BidSize: 100, AskSize: 100}) // Nothing left
+
+	reports := log.of(id)
+	want := []struct {
+		status          OrderStatus
+		last, cum, left float64
+	}{
+		{OrderNew, 0, 0, 120},
+		{OrderPartiallyFilled, 50, 50, 70},
+		{OrderPartiallyFilled, 50, 100, 20},
+		{OrderFilled, 20, 120, 0},
+	}
+	if len(reports) != len(want) {
+		t.Fatalf("got %d reports, want %d: %+v", len(reports), len(want), reports)
+	}
+	for i, w := range want {
+		r := reports[i]
+		if r.Status != w.status || r.LastQty != w.last || r.CumQty != w.cum || r.LeavesQty != w.left || r.ClientID != "c1" {
+			t.Errorf("report %d = %+v, want %v last=%v cum=%v leaves=%v", i, r, w.status, w.last, w.cum, w.left)
+		}
+	}
+	if avg := (50*101 + 50*102 + 20*103) / 120.0; !closeTo(reports[3].AvgPrice, avg) {
+		t.Errorf("AvgPrice = %v, want %v", reports[3].AvgPrice, avg)
+	}
+	if r, _ := b.Order(id); r.Status != OrderFilled {
+		t.Errorf("Order status = %v, want filled", r.Status)
+	}
+}
+
+func TestPaperBrokerLimitAndQueue(t *testing.T) {
+	b, log := newTestBroker(PaperBrokerConfig{})
+	feed := b.Feed("X")
+	limit, _ := b.PlaceOrder(OrderRequest{Symbol: "X", Side: Sell, Type: LimitOrder, Quantity: 10, LimitPrice: 100})
+	first, _ := b.PlaceOrder(OrderRequest{Symbol: "X", Side: Buy, Quantity: 30})
+	second, _ := b.PlaceOrder(OrderRequest{Symbol: "X", Side: Buy, Quantity: 30})
+
+	feed.OnQuote(Quote{Bid: 99, Ask: 101, BidSize: 50, AskSize: 40})
+	if r, _ := b.Order(limit); r.Status != OrderNew {
+		t.Errorf("sell limit at 100 with bid 99: %v, want new", r.Status)
+	}
+	if r, _ := b.Order(first); r.Status != OrderFilled {
+		t.Errorf("first buy: %v, want filled", r.Status)
+	}
+	if r, _ := b.Order(second); r.Status != OrderPartiallyFilled || r.CumQty != 10 {
+		t.Errorf("second buy: %v with %v filled, want the 10 left by the first", r.Status, r.CumQty)
+	}
+
+	feed.OnQuote(Quote{Bid: 100.5, Ask: 101, BidSize: 50, AskSize: 40})
+	if r, _ := b.Order(limit); r.Status != OrderFilled || r.LastPrice != 100.5 {
+		t.Errorf("sell limit: %v at %v, want filled at 100.5", r.Status, r.LastPrice)
+	}
+	if n := len(log.of(second)); n != 3 {
+		t.Errorf("second buy has %d reports, want 3", n)
+	}
+}
+
+func TestPaperBrokerCancel(t *testing.T) {
+	b, log := newTestBroker(PaperBrokerConfig{})
+	feed := b.Feed("X")
+	id, _ := b.PlaceOrder(OrderRequest{Symbol: "X", Side: Buy, Quantity: 30})
+	feed.OnQuote(Quote{Bid: 99, Ask: 101, BidSize: 10, AskSize: 10})
+
+	if err := b.CancelOrder(id); err != nil {
+		t.Fatal(err)
+	}
+	feed.OnQuote(Quote{Bid: 99, Ask: 101, BidSize: 10, AskSize: 10})
+
+	reports := log.of(id)
+	last := reports[len(reports)-1]
+	if last.Status != OrderCanceled || last.CumQty != 10 || last.LeavesQty != 0 {
+		t.Errorf("after cancel: %+v, want canceled with the 10 filled before", last)
+	}
+	if err := b.CancelOrder(id); err == nil {
+		t.Error("canceling a canceled order succeeded")
+	}
+	if err := b.CancelOrder("nope"); err == nil {
+		t.Error("canceling an unknown order succeeded")
+	}
+}
+
+func TestPaperBrokerRejects(t *testing.T) {
+	risk := NewRiskManager(RiskLimits{MaxPosition: 5})
+	b, log := newTestBroker(PaperBrokerConfig{Risk: risk})
+
+	for _, req := range []OrderRequest{
+		{Side: Buy, Quantity: 1},
+		{Symbol: "X", Side: Buy, Quantity: 0},
+		{Symbol: "X", Side: Buy, Type: LimitOrder, Quantity: 1},
+		{Symbol: "X", Side: Buy, Quantity: 10}, // Over the position limit
+	} {
+		id, err := b.PlaceOrder(req)
+		if err == nil {
+			t.Errorf("%+v accepted", req)
+			continue
+		}
+		if r := log.of(id); len(r) != 1 || r[0].Status != OrderRejected || r[0].Text == "" {
+			t.Errorf("%+v: reports %+v, want one rejection with a reason", req, r)
+		}
+	}
+}
+
+func TestPaperBrokerRiskListenerCallsBack(t *testing.T) {
+	risk := NewRiskManager(RiskLimits{MaxPosition: 5})
+	b, _ := newTestBroker(PaperBrokerConfig{Risk: risk})
+	working, _ := b.PlaceOrder(OrderRequest{Symbol: "X", Side: Buy, Quantity: 5})
+	// Cancel everything on a breach, from inside the risk check
+	risk.OnReject(func(Rejection) {
+		b.CancelOrder(working)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.PlaceOrder(OrderRequest{Symbol: "X", Side: Sell, Quantity: 20})
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("PlaceOrder deadlocked with a risk listener calling the broker")
+	}
+	if r, _ := b.Order(working); r.Status != OrderCanceled {
+		t.Errorf("working order %v, want canceled by the listener", r.Status)
+	}
+}
@@ -0,0 +1,176 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"math"
+	"slices"
+	"sync"
+)
+
+// predictiveDensity returns the posterior predictive density of x, a Student-t
+// with 2*Alpha degrees of freedom.
+func (p NIGParams) predictiveDensity(x float64) float64 {
+	scale := math.Sqrt(p.Beta * (p.Kappa + 1) / (p.Alpha * p.Kappa))
+	return studentTPDF((x-p.Mu)/scale, 2*p.Alpha) / scale
+}
+
+// Resettable is implemented by estimators that a ChangePointDetector can
+// restart when the regime changes.
+type Resettable interface {
+	Reset(prior NIGParams)
+}
+
+// ChangePointState is what a ChangePointDetector publishes on every tick.
+type ChangePointState struct {
+	Tick        int     // Number of observations so far
+	RunLength   int     // Most likely number of observations since the last change
+	Probability float64 // Posterior probability that a change happened within the last few ticks
+	Changed     bool    // Whether this tick triggered a change
+}
+
+// ChangePointDetector is an observer running Adams and MacKay's online
+// Bayesian change-point detection with a Normal-Inverse-Gamma model per run.
+type ChangePointDetector struct {
+	prior     NIGParams
+	hazard    float64 // Prior probability of a change on any tick
+	maxRun    int     // Run lengths beyond this are merged into the last slot
+	recent    int     // Runs shorter than this count towards Probability
+	threshold float64 // Probability that triggers a change
+
+	runProbs   []float64   // P(r_t = i | x_1..t)
+	runParams  []NIGParams // Posterior for each run length
+	state      ChangePointState
+	armed      bool // Cleared after a change until Probability falls below threshold
+	estimators []Resettable
+	reprior    bool
+	listeners  []func(ChangePointState)
+	mux        sync.Mutex
+}
+
+// NewChangePointDetector creates a detector expecting a change every
+// expectedRun ticks on average. A change is reported when the probability of
+// a run shorter than recent ticks exceeds threshold.
+func NewChangePointDetector(prior NIGParams, expectedRun float64, recent int, threshold float64) *ChangePointDetector {
+	return &ChangePointDetector{
+		prior:     prior,
+		hazard:    1 / expectedRun,
+		maxRun:    int(10 * expectedRun),
+		recent:    recent,
+		threshold: threshold,
+		runProbs:  []float64{1},
+		runParams: []NIGParams{prior},
+		armed:     true,
+	}
+}
+
+// ResetOnChange makes the detector reset the given estimators on every
+// change. With reprior set they restart from the posterior of the new run
+// instead of the detector's prior.
+func (d *ChangePointDetector) ResetOnChange(reprior bool, estimators ...Resettable) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.reprior = reprior
+	d.estimators = append(d.estimators, estimators...)
+}
+
+// Subscribe registers a function called with the detector state after every tick.
+func (d *ChangePointDetector) Subscribe(fn func(ChangePointState)) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.listeners = append(d.listeners, fn)
+}
+
+// State returns the state after the latest tick.
+func (d *ChangePointDetector) State() ChangePointState {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.state
+}
+
+// Update implements Observer.
+func (d *ChangePointDetector) Update(price float64) {
+	d.mux.Lock()
+	state, reset, prior := d.step(price)
+	estimators := slices.Clone(d.estimators)
+	listeners := slices.Clone(d.listeners)
+	d.mux.Unlock()
+
+	if reset {
+		for _, e := range estimators {
+			e.Reset(prior)
+		}
+	}
+	for _, fn := range listeners {
+		fn(state)
+	}
+}
+
+// step advances the run-length posterior by one observation. It reports
+// whether estimators should be reset and with which prior.
+func (d *ChangePointDetector) step(x float64) (ChangePointState, bool, NIGParams) {
+	n := len(d.runProbs)
+	next := make([]float64, n+1)
+	for i, p := range d.runProbs {
+		pred := p * d.runParams[i].predictiveDensity(x)
+		next[0] += pred * d.hazard
+		next[i+1] = pred * (1 - d.hazard)
+	}
+
+	params := make([]NIGParams, n+1)
+	params[0] = d.prior
+	for i, p := range d.runParams {
+		params[i+1] = p.update(x)
+	}
+
+	// Merge the longest runs so the state stays bounded
+	if len(next) > d.maxRun+1 {
+		next[d.maxRun] += next[d.maxRun+1]
+		next, params = next[:d.maxRun+1], params[:d.maxRun+1]
+	}
+
+	var total float64
+	for _, p := range next {
+		total += p
+	}
+	if total == 0 || math.IsNaN(total) {
+		// Every run assigns x zero density: treat it as a certain change
+		next = []float64{1}
+		params = params[:1]
+		total = 1
+	}
+	mapRun, shortRun, recent := 0, 0, 0.0
+	for i := range next {
+		next[i] /= total
+		if next[i] > next[mapRun] {
+			mapRun = i
+		}
+		if i < d.recent {
+			recent += next[i]
+			if next[i] > next[shortRun] {
+				shortRun = i
+			}
+		}
+	}
+	d.runProbs, d.runParams = next, params
+
+	d.state = ChangePointState{
+		Tick:        d.state.Tick + 1,
+		RunLength:   mapRun,
+		Probability: recent,
+	}
+	// Skip the warm-up, when every run is short, and fire once per change
+	if d.state.Tick > 2*d.recent && d.armed && recent > d.threshold {
+		d.state.Changed = true
+		d.armed = false
+	} else if recent < d.threshold {
+		d.armed = true
+	}
+
+	prior := d.prior
+	if d.reprior {
+		prior = params[shortRun] // Posterior of the most likely new run
+	}
+	return d.state, d.state.Changed && len(d.estimators) > 0, prior
+}
@@ -0,0 +1,84 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// fixedModel is a Model with a fixed posterior.
+type fixedModel struct {
+	MeanPosterior
+}
+
+func (m fixedModel) Update(float64)           {}
+func (m fixedModel) Posterior() MeanPosterior { return m.MeanPosterior }
+func (m fixedModel) CredibleInterval(level float64) (lo, hi float64) {
+	return m.MeanPosterior.CredibleInterval(level)
+}
+
+func TestProbabilityGreaterMatchesMonteCarlo(t *testing.T) {
+	rng := rand.New(rand.NewSource(5))
+	const draws = 400000
+	for _, tc := range []struct {
+		name string
+		a, b MeanPosterior
+	}{
+		{"normal", MeanPosterior{Mean: 1, Scale: 1, DF: math.Inf(1)}, MeanPosterior{Mean: 0, Scale: 2, DF: math.Inf(1)}},
+		{"student", MeanPosterior{Mean: 0.3, Scale: 1, DF: 5}, MeanPosterior{Mean: 0, Scale: 0.5, DF: 3}},
+		{"normal against student", MeanPosterior{Mean: 0, Scale: 1, DF: math.Inf(1)}, MeanPosterior{Mean: 0.5, Scale: 1, DF: 4}},
+		{"cauchy", MeanPosterior{Mean: 1, Scale: 1, DF: 1}, MeanPosterior{Mean: 0, Scale: 1, DF: 1}},
+		{"df below 1", MeanPosterior{Mean: 0.5, Scale: 1, DF: 3}, MeanPosterior{Mean: 0, Scale: 1, DF: 0.5}},
+		{"far apart", MeanPosterior{Mean: 10, Scale: 0.1, DF: 8}, MeanPosterior{Mean: 0, Scale: 0.1, DF: 8}},
+	} {
+		exact := probabilityGreater(tc.a, tc.b)
+		mc := probabilityGreaterMC(tc.a, tc.b, rng, draws)
+		// Four standard errors of the Monte Carlo estimate, plus integration error
+		tol := 4*math.Sqrt(mc*(1-mc)/draws) + 1e-4
+		if math.IsNaN(exact) || math.Abs(exact-mc) > tol {
+			t.Errorf("%s: analytic %v, Monte Carlo %v", tc.name, exact, mc)
+		}
+	}
+}
+
+func TestProbabilityGreaterIntegration(t *testing.T) {
+	// Degrees of freedom past normalDF are integrated as normals, which have a closed form
+	a := MeanPosterior{Mean: 1, Scale: 1, DF: 2 * normalDF}
+	b := MeanPosterior{Mean: 0, Scale: 2, DF: 2 * normalDF}
+	want := normalCDF(1 / math.Sqrt(5))
+	if got := probabilityGreater(a, b); math.Abs(got-want) > 1e-6 {
+		t.Errorf("integrated %v, want %v", got, want)
+	}
+}
+
+func TestComparatorConcurrentMonteCarlo(t *testing.T) {
+	a := fixedModel{MeanPosterior{Mean: 1, Scale: 1, DF: 5}}
+	b := fixedModel{MeanPosterior{Mean: 0, Scale: 1, DF: 5}}
+	c := NewComparator(a, b, rand.New(rand.NewSource(6)))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.ProbabilityMonteCarlo(1000)
+		}()
+	}
+	wg.Wait()
+
+	data, err := json.Marshal(c.PairSignal(0.95))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	json.Unmarshal(data, &fields)
+	for _, key := range []string{"signal", "probability", "posterior_mean_a", "posterior_mean_b", "confidence"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("PairDecision JSON %s lacks %q", data, key)
+		}
+	}
+}
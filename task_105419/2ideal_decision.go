@@ -0,0 +1,83 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// decisionCredibleLevel is the credible interval reported with every decision.
+const decisionCredibleLevel = 0.95
+
+// Decision is a trading signal with the evidence that produced it.
+type Decision struct {
+	Time        time.Time `json:"time"`
+	Symbol      string    `json:"symbol,omitempty"`
+	Signal      int       `json:"signal"`         // 1 buy, -1 sell, 0 hold
+	Probability float64   `json:"probability"`    // Posterior P(mean > Threshold)
+	Mean        float64   `json:"posterior_mean"` // Posterior mean of the mean
+	Lower       float64   `json:"ci_lower"`       // 95% credible interval for the mean
+	Upper       float64   `json:"ci_upper"`
+	Threshold   float64   `json:"threshold"`
+	Confidence  float64   `json:"confidence"`
+	Count       int       `json:"observations"`
+	Suppressed  string    `json:"suppressed,omitempty"` // Why a gate vetoed the signal
+}
+
+// String formats the decision on one line.
+func (d Decision) String() string {
+	s := fmt.Sprintf("signal=%d P(mean > %.4g)=%.3f mean=%.4g 95%% CI=[%.4g, %.4g] confidence=%.2f n=%d",
+		d.Signal, d.Threshold, d.Probability, d.Mean, d.Lower, d.Upper, d.Confidence, d.Count)
+	if d.Suppressed != "" {
+		s += " suppressed: " + d.Suppressed
+	}
+	return s
+}
+
+// AuditLog appends decisions and risk rejections to a JSONL file, one record
+// per line.
+type AuditLog struct {
+	f   *os.File
+	enc *json.Encoder
+	mux sync.Mutex
+}
+
+// OpenAuditLog opens path for appending, creating it if needed. Existing
+// records are never rewritten.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// Record appends one decision.
+func (a *AuditLog) Record(d Decision) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.enc.Encode(d)
+}
+
+// RecordRejection appends one order rejected by a RiskManager.
+func (a *AuditLog) RecordRejection(r Rejection) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.enc.Encode(r)
+}
+
+// Close flushes the log to disk and closes it.
+func (a *AuditLog) Close() error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if err := a.f.Sync(); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
@@ -0,0 +1,167 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// normalDF is the number of degrees of freedom beyond which a Student-t is
+// treated as normal; the log-gamma terms lose precision well before +Inf.
+const normalDF = 1e7
+
+// normalCDF returns P(Z <= z) for a standard normal Z.
+func normalCDF(z float64) float64 {
+	return 0.5 * (1 + math.Erf(z/math.Sqrt2))
+}
+
+// normalQuantile returns the z such that P(Z <= z) = p for a standard normal Z.
+func normalQuantile(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+// studentTCDF returns P(T <= t) for a standard Student-t T with df degrees of freedom.
+func studentTCDF(t, df float64) float64 {
+	if df > normalDF {
+		return normalCDF(t)
+	}
+	x := df / (df + t*t)
+	tail := 0.5 * regIncBeta(df/2, 0.5, x)
+	if t > 0 {
+		return 1 - tail
+	}
+	return tail
+}
+
+// studentTQuantile inverts studentTCDF by bisection.
+func studentTQuantile(p, df float64) float64 {
+	if df > normalDF {
+		return normalQuantile(p)
+	}
+	lo, hi := -1.0, 1.0
+	for studentTCDF(lo, df) > p {
+		lo *= 2
+	}
+	for studentTCDF(hi, df) < p {
+		hi *= 2
+	}
+	for i := 0; i < 100 && hi-lo > 1e-10; i++ {
+		mid := (lo + hi) / 2
+		if studentTCDF(mid, df) < p {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return (lo + hi) / 2
+}
+
+// regIncBeta returns the regularized incomplete beta function I_x(a, b).
+func regIncBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+	// The continued fraction converges quickly only on this side of the mode.
+	if x < (a+1)/(a+b+2) {
+		return front * betaContinuedFraction(a, b, x) / a
+	}
+	return 1 - front*betaContinuedFraction(b, a, 1-x)/b
+}
+
+// betaContinuedFraction evaluates the continued fraction for the incomplete
+// beta function using the modified Lentz method.
+func betaContinuedFraction(a, b, x float64) float64 {
+	const (
+		maxIter = 300
+		eps     = 1e-14
+		tiny    = 1e-300
+	)
+	qab, qap, qam := a+b, a+1, a-1
+	c, d := 1.0, 1-qab*x/qap
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= maxIter; m++ {
+		fm := float64(m)
+		m2 := 2 * fm
+		aa := fm * (b - fm) * x / ((qam + m2) * (a + m2))
+		d = 1 + aa*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+		aa = -(a + fm) * (qab + fm) * x / ((a + m2) * (qap + m2))
+		d = 1 + aa*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < eps {
+			break
+		}
+	}
+	return h
+}
+
+// studentTPDF returns the density of a standard Student-t with df degrees of freedom at t.
+func studentTPDF(t, df float64) float64 {
+	if df > normalDF {
+		return math.Exp(-t*t/2) / math.Sqrt(2*math.Pi)
+	}
+	a, _ := math.Lgamma((df + 1) / 2)
+	b, _ := math.Lgamma(df / 2)
+	return math.Exp(a - b - 0.5*math.Log(df*math.Pi) - (df+1)/2*math.Log1p(t*t/df))
+}
+
+// sampleGamma draws from Gamma(shape, 1) using Marsaglia and Tsang's method.
+func sampleGamma(rng *rand.Rand, shape float64) float64 {
+	if shape < 1 {
+		// Boost the shape and correct with a uniform power
+		return sampleGamma(rng, shape+1) * math.Pow(rng.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rng.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
+
+// sampleStudentT draws from a standard Student-t with df degrees of freedom.
+func sampleStudentT(rng *rand.Rand, df float64) float64 {
+	z := rng.NormFloat64()
+	if df > normalDF {
+		return z
+	}
+	return z / math.Sqrt(2*sampleGamma(rng, df/2)/df)
+}
@@ -0,0 +1,47 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStudentTCDF(t *testing.T) {
+	tests := []struct {
+		t, df, want float64
+	}{
+		{0, 3, 0.5},
+		{1, 1, 0.75}, // Cauchy: 1/2 + atan(t)/pi
+		{-3, 1, 0.5 + math.Atan(-3)/math.Pi},
+		{2, 2, 0.5 + 2/(2*math.Sqrt(6))}, // df 2: 1/2 + t/(2 sqrt(2+t^2))
+		{2.228138851986, 10, 0.975},
+		{-2.015048373, 5, 0.05},
+		{1.959963985, 1e9, 0.975}, // Normal limit
+	}
+	for _, tt := range tests {
+		if got := studentTCDF(tt.t, tt.df); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("studentTCDF(%v, %v) = %.12f, want %.12f", tt.t, tt.df, got, tt.want)
+		}
+	}
+}
+
+func TestStudentTQuantile(t *testing.T) {
+	tests := []struct {
+		p, df, want float64
+	}{
+		{0.5, 4, 0},
+		{0.75, 1, 1},
+		{0.975, 1, 12.706204736},
+		{0.95, 5, 2.015048373},
+		{0.975, 10, 2.228138852},
+		{0.005, 30, -2.749995653},
+		{0.975, 1e9, 1.959963985},
+	}
+	for _, tt := range tests {
+		if got := studentTQuantile(tt.p, tt.df); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("studentTQuantile(%v, %v) = %.9f, want %.9f", tt.p, tt.df, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// defaultObserverBuffer is the delivery buffer used by Attach and AttachBlocking.
+const defaultObserverBuffer = 64
+
+// DeliveryPolicy decides what happens when an observer's buffer is full.
+type DeliveryPolicy int
+
+const (
+	DeliverBlock    DeliveryPolicy = iota // Wait for buffer space, stalling the source
+	DeliverDrop                           // Drop the new price
+	DeliverCoalesce                       // Replace the oldest buffered price with the new one
+)
+
+// ObserverStats reports how far an observer lags behind its source.
+type ObserverStats struct {
+	Observer  Observer
+	Policy    DeliveryPolicy
+	Pending   int    // Prices buffered but not yet delivered
+	Delivered uint64 // Prices passed to Update
+	Dropped   uint64 // Prices discarded by DeliverDrop
+	Coalesced uint64 // Prices overwritten by DeliverCoalesce
+}
+
+// subscription is one observer with its own buffer and delivery goroutine.
+type subscription struct {
+	observer  Observer
+	policy    DeliveryPolicy
+	ch        chan float64
+	done      chan struct{} // Closed by Detach
+	sending   sync.RWMutex  // Held for reading while a price is being enqueued
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	coalesced atomic.Uint64
+}
+
+// observerSet fans prices out to observers, each on its own goroutine. Only
+// DeliverBlock observers can stall the source, and with it the others, so
+// they are for observers that must see every price.
+type observerSet struct {
+	subs     []*subscription
+	inflight sync.WaitGroup // Prices enqueued but not yet delivered or discarded
+	mux      sync.RWMutex
+}
+
+// Attach adds an observer that only needs the latest prices: when it falls
+// behind, its oldest buffered price is replaced.
+func (s *observerSet) Attach(observer Observer) {
+	s.AttachBuffered(observer, DeliverCoalesce, defaultObserverBuffer)
+}
+
+// AttachBlocking adds an observer that must see every price, such as an
+// estimator or a backtester. When its buffer is full the source waits.
+func (s *observerSet) AttachBlocking(observer Observer) {
+	s.AttachBuffered(observer, DeliverBlock, defaultObserverBuffer)
+}
+
+// AttachBuffered adds an observer with the given overflow policy and buffer size.
+func (s *observerSet) AttachBuffered(observer Observer, policy DeliveryPolicy, buffer int) {
+	if buffer < 1 {
+		buffer = 1
+	}
+	sub := &subscription{
+		observer: observer,
+		policy:   policy,
+		ch:       make(chan float64, buffer),
+		done:     make(chan struct{}),
+	}
+	go s.deliver(sub)
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.subs = append(s.subs, sub)
+}
+
+// Detach removes an observer. Prices already buffered for it are still
+// delivered. It does not wait, so an observer may detach itself from Update.
+func (s *observerSet) Detach(observer Observer) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	for i, sub := range s.subs {
+		if sub.observer == observer {
+			close(sub.done)
+			s.subs = slices.Delete(s.subs, i, i+1)
+			return
+		}
+	}
+}
+
+// Stats returns delivery counters for every attached observer.
+func (s *observerSet) Stats() []ObserverStats {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	stats := make([]ObserverStats, 0, len(s.subs))
+	for _, sub := range s.subs {
+		stats = append(stats, ObserverStats{
+			Observer:  sub.observer,
+			Policy:    sub.policy,
+			Pending:   len(sub.ch),
+			Delivered: sub.delivered.Load(),
+			Dropped:   sub.dropped.Load(),
+			Coalesced: sub.coalesced.Load(),
+		})
+	}
+	return stats
+}
+
+// Drain waits until every price published so far has been delivered or discarded.
+func (s *observerSet) Drain() {
+	s.inflight.Wait()
+}
+
+// deliver runs an observer's Update for each buffered price until it is detached.
+func (s *observerSet) deliver(sub *subscription) {
+	update := func(price float64) {
+		sub.observer.Update(price)
+		sub.delivered.Add(1)
+		s.inflight.Done()
+	}
+	for {
+		select {
+		case price := <-sub.ch:
+			update(price)
+		case <-sub.done:
+			// Wait out enqueues in progress, then deliver what they buffered
+			sub.sending.Lock()
+			sub.sending.Unlock()
+			for {
+				select {
+				case price := <-sub.ch:
+					update(price)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// notify enqueues a price for every attached observer according to its policy.
+// The observers are read under the lock but sent to without it, so Attach,
+// Detach and Stats never wait for a slow observer.
+func (s *observerSet) notify(ctx context.Context, price float64) {
+	s.mux.RLock()
+	subs := slices.Clone(s.subs)
+	s.mux.RUnlock()
+	for _, sub := range subs {
+		s.enqueue(ctx, sub, price)
+	}
+}
+
+// enqueue buffers a price for one observer. A blocking observer stops waiting
+// when ctx is cancelled or it is detached.
+func (s *observerSet) enqueue(ctx context.Context, sub *subscription, price float64) {
+	sub.sending.RLock()
+	defer sub.sending.RUnlock()
+	select {
+	case <-sub.done:
+		return
+	default:
+	}
+
+	s.inflight.Add(1)
+	select {
+	case sub.ch <- price:
+		return
+	default:
+	}
+
+	switch sub.policy {
+	case DeliverBlock:
+		select {
+		case sub.ch <- price:
+		case <-ctx.Done():
+			s.inflight.Done()
+		case <-sub.done:
+			s.inflight.Done()
+		}
+	case DeliverDrop:
+		sub.dropped.Add(1)
+		s.inflight.Done()
+	case DeliverCoalesce:
+		for sent := false; !sent; {
+			select {
+			case sub.ch <- price:
+				sent = true
+			case <-sub.ch:
+				sub.coalesced.Add(1)
+				s.inflight.Done()
+			}
+		}
+	}
+}
+
+// observerChain delivers each price to its observers in order on one goroutine.
+type observerChain struct {
+	observers []Observer
+}
+
+// Chain returns an Observer that updates the given observers in sequence.
+// Use it when an observer reads state from another, such as a backtester
+// polling an estimator's signal after the estimator has seen the price.
+func Chain(observers ...Observer) Observer {
+	return &observerChain{observers: observers}
+}
+
+// Update implements Observer.
+func (c *observerChain) Update(price float64) {
+	for _, observer := range c.observers {
+		observer.Update(price)
+	}
+}
@@ -0,0 +1,176 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is an observer that records every price, optionally waiting for
+// release before each update.
+type recorder struct {
+	release chan struct{} // Nil updates at once
+	onPrice func(float64)
+	prices  []float64
+	mux     sync.Mutex
+}
+
+// Update implements Observer.
+func (r *recorder) Update(price float64) {
+	if r.release != nil {
+		<-r.release
+	}
+	if r.onPrice != nil {
+		r.onPrice(price)
+	}
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.prices = append(r.prices, price)
+}
+
+// seen returns the prices recorded so far.
+func (r *recorder) seen() []float64 {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return slices.Clone(r.prices)
+}
+
+// within fails the test unless fn returns before the timeout.
+func within(t *testing.T, what string, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not return", what)
+	}
+}
+
+// statsOf returns the delivery counters of observer.
+func statsOf(s *observerSet, observer Observer) ObserverStats {
+	for _, st := range s.Stats() {
+		if st.Observer == observer {
+			return st
+		}
+	}
+	return ObserverStats{}
+}
+
+func TestDeliverBlockKeepsEveryPrice(t *testing.T) {
+	var s observerSet
+	r := &recorder{}
+	s.AttachBuffered(r, DeliverBlock, 1)
+	var want []float64
+	for i := 0; i < 100; i++ {
+		want = append(want, float64(i))
+		s.notify(context.Background(), float64(i))
+	}
+	within(t, "Drain", s.Drain)
+	if got := r.seen(); !slices.Equal(got, want) {
+		t.Errorf("blocking observer saw %v, want %v", got, want)
+	}
+}
+
+func TestDeliveryPolicies(t *testing.T) {
+	for _, policy := range []DeliveryPolicy{DeliverDrop, DeliverCoalesce} {
+		var s observerSet
+		slow := &recorder{release: make(chan struct{})}
+		s.AttachBuffered(slow, policy, 4)
+		within(t, "notify", func() {
+			for i := 0; i < 50; i++ {
+				s.notify(context.Background(), float64(i))
+			}
+		})
+		close(slow.release)
+		within(t, "Drain", s.Drain)
+
+		got := slow.seen()
+		st := statsOf(&s, slow)
+		if int(st.Delivered) != len(got) || int(st.Delivered+st.Dropped+st.Coalesced) != 50 {
+			t.Errorf("policy %d: saw %d prices with stats %+v, want them to account for 50", policy, len(got), st)
+		}
+		if !slices.IsSorted(got) {
+			t.Errorf("policy %d: prices out of order: %v", policy, got)
+		}
+		last := got[len(got)-1]
+		switch policy {
+		case DeliverDrop:
+			if st.Dropped == 0 || last == 49 {
+				t.Errorf("drop: last price %v with %d dropped, want the newest prices dropped", last, st.Dropped)
+			}
+		case DeliverCoalesce:
+			if st.Coalesced == 0 || last != 49 {
+				t.Errorf("coalesce: last price %v with %d coalesced, want the newest price kept", last, st.Coalesced)
+			}
+		}
+	}
+}
+
+func TestSlowObserverDoesNotStallOthers(t *testing.T) {
+	var s observerSet
+	slow := &recorder{release: make(chan struct{})}
+	fast := &recorder{}
+	s.Attach(slow)
+	s.AttachBlocking(fast)
+
+	within(t, "notify", func() {
+		for i := 0; i < 200; i++ {
+			s.notify(context.Background(), float64(i))
+		}
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fast.seen()) < 200 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := len(fast.seen()); n != 200 {
+		t.Errorf("fast observer saw %d of 200 prices", n)
+	}
+	within(t, "Stats", func() { s.Stats() })
+	within(t, "Detach", func() { s.Detach(slow) })
+	close(slow.release)
+	within(t, "Drain", s.Drain)
+}
+
+func TestDetachUnblocksBlockedSource(t *testing.T) {
+	var s observerSet
+	slow := &recorder{release: make(chan struct{})}
+	s.AttachBuffered(slow, DeliverBlock, 1)
+
+	notified := make(chan struct{})
+	go func() {
+		defer close(notified)
+		for i := 0; i < 10; i++ {
+			s.notify(context.Background(), float64(i))
+		}
+	}()
+	time.Sleep(10 * time.Millisecond) // Let the source block on the full buffer
+	within(t, "Detach", func() { s.Detach(slow) })
+	within(t, "notify", func() { <-notified })
+	close(slow.release)
+	within(t, "Drain", s.Drain)
+}
+
+func TestDetachFromUpdate(t *testing.T) {
+	var s observerSet
+	r := &recorder{}
+	r.onPrice = func(float64) { s.Detach(r) }
+	s.AttachBuffered(r, DeliverBlock, 1)
+	within(t, "notify", func() {
+		for i := 0; i < 10; i++ {
+			s.notify(context.Background(), float64(i))
+		}
+	})
+	within(t, "Drain", s.Drain)
+	if n := len(s.Stats()); n != 0 {
+		t.Errorf("%d observers still attached", n)
+	}
+}
@@ -0,0 +1,267 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// hierarchicalIterations bounds the fixed-point iterations of the hyperprior fit.
+const hierarchicalIterations = 500
+
+// GroupPosterior is the learned hyperprior of a group: symbol means are
+// modelled as draws from N(Mean, Tau^2).
+type GroupPosterior struct {
+	Mean      float64 // Estimated group mean
+	MeanScale float64 // Standard error of Mean
+	Tau       float64 // Spread of the symbol means around Mean
+	Members   int     // Symbols in the group
+	Informed  int     // Symbols with observations, which the fit uses
+}
+
+// hierarchicalMember is one symbol of a group.
+type hierarchicalMember struct {
+	symbol string
+	model  Model
+}
+
+// HierarchicalModel partially pools the means of symbols in the same group,
+// typically a sector. Each symbol keeps its own Model, usually a
+// BayesianEstimator with a weak prior; its posterior mean and variance are
+// treated as a noisy measurement of the symbol's true mean, and the group's
+// hyperprior is fitted to them by marginal maximum likelihood. Thinly traded
+// symbols, whose posteriors are wide, are pulled strongly towards the group
+// mean, while well-observed symbols mostly keep their own.
+type HierarchicalModel struct {
+	groups  map[string][]hierarchicalMember
+	symbols map[string]string // Symbol to group
+	mux     sync.Mutex
+}
+
+// NewHierarchicalModel creates an empty model.
+func NewHierarchicalModel() *HierarchicalModel {
+	return &HierarchicalModel{
+		groups:  make(map[string][]hierarchicalMember),
+		symbols: make(map[string]string),
+	}
+}
+
+// Add places symbol, modelled by m, in group.
+func (h *HierarchicalModel) Add(group, symbol string, m Model) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if g, ok := h.symbols[symbol]; ok {
+		return fmt.Errorf("symbol %s is already in group %s", symbol, g)
+	}
+	h.symbols[symbol] = group
+	h.groups[group] = append(h.groups[group], hierarchicalMember{symbol: symbol, model: m})
+	return nil
+}
+
+// Groups returns the group names in sorted order.
+func (h *HierarchicalModel) Groups() []string {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	groups := make([]string, 0, len(h.groups))
+	for g := range h.groups {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Group returns the fitted hyperprior of group.
+func (h *HierarchicalModel) Group(group string) (GroupPosterior, error) {
+	members, err := h.members(group)
+	if err != nil {
+		return GroupPosterior{}, err
+	}
+	g, _, _ := fitGroup(members)
+	return g, nil
+}
+
+// Shrunk returns the partially pooled posterior of symbol's mean.
+func (h *HierarchicalModel) Shrunk(symbol string) (MeanPosterior, error) {
+	h.mux.Lock()
+	group, ok := h.symbols[symbol]
+	h.mux.Unlock()
+	if !ok {
+		return MeanPosterior{}, fmt.Errorf("unknown symbol %q", symbol)
+	}
+	members, err := h.members(group)
+	if err != nil {
+		return MeanPosterior{}, err
+	}
+	g, posts, variances := fitGroup(members)
+	for i, m := range members {
+		if m.symbol == symbol {
+			return shrink(g, posts[i], variances[i]), nil
+		}
+	}
+	return MeanPosterior{}, fmt.Errorf("unknown symbol %q", symbol)
+}
+
+// Model returns a Model for symbol whose posterior is the shrunk one, so it
+// can be used wherever a single-symbol model is expected. Updates go to the
+// symbol's own model.
+func (h *HierarchicalModel) Model(symbol string) (Model, error) {
+	if _, err := h.Shrunk(symbol); err != nil {
+		return nil, err
+	}
+	return &shrunkModel{h: h, symbol: symbol}, nil
+}
+
+// members returns a copy of the members of group.
+func (h *HierarchicalModel) members(group string) ([]hierarchicalMember, error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	members, ok := h.groups[group]
+	if !ok {
+		return nil, fmt.Errorf("unknown group %q", group)
+	}
+	return slices.Clone(members), nil
+}
+
+// fitGroup estimates the hyperprior from the members' posteriors, returning it
+// with each member's posterior and posterior variance of the mean.
+func fitGroup(members []hierarchicalMember) (GroupPosterior, []MeanPosterior, []float64) {
+	posts := make([]MeanPosterior, len(members))
+	variances := make([]float64, len(members))
+	var means, vars []float64
+	for i, m := range members {
+		posts[i] = m.model.Posterior()
+		variances[i] = posteriorVariance(posts[i])
+		// Symbols without data only echo their prior
+		if posts[i].Count > 0 && !math.IsInf(variances[i], 1) {
+			means = append(means, posts[i].Mean)
+			vars = append(vars, variances[i])
+		}
+	}
+	g := GroupPosterior{Members: len(members), Informed: len(means)}
+	if len(means) == 0 {
+		g.Mean, g.MeanScale, g.Tau = math.NaN(), math.Inf(1), math.NaN()
+		return g, posts, variances
+	}
+
+	// Marginal ML: m_i ~ N(mu, v_i + tau^2). Start tau^2 at the spread of the means.
+	mu, tau2 := mean(means), 0.0
+	for _, m := range means {
+		tau2 += (m - mu) * (m - mu)
+	}
+	tau2 /= float64(len(means))
+	var wsum float64
+	for iter := 0; iter < hierarchicalIterations; iter++ {
+		var wm, w2, w2r float64
+		wsum = 0
+		for i, m := range means {
+			w := 1 / (vars[i] + tau2)
+			wsum += w
+			wm += w * m
+		}
+		mu = wm / wsum
+		for i, m := range means {
+			w := 1 / (vars[i] + tau2)
+			w2 += w * w
+			w2r += w * w * ((m-mu)*(m-mu) - vars[i])
+		}
+		next := math.Max(0, w2r/w2)
+		if math.Abs(next-tau2) <= 1e-12*math.Max(tau2, 1e-300) {
+			tau2 = next
+			break
+		}
+		tau2 = next
+	}
+	wsum = 0
+	for _, v := range vars {
+		wsum += 1 / (v + tau2)
+	}
+	g.Mean = mu
+	g.MeanScale = math.Sqrt(1 / wsum)
+	g.Tau = math.Sqrt(tau2)
+	return g, posts, variances
+}
+
+// shrink combines a member's posterior with the group hyperprior. The
+// shrinkage weight B = v/(v + tau^2) moves the mean towards the group, and
+// the variance includes the uncertainty of the group mean.
+func shrink(g GroupPosterior, post MeanPosterior, variance float64) MeanPosterior {
+	if math.IsNaN(g.Mean) {
+		return post
+	}
+	tau2 := g.Tau * g.Tau
+	b := 1.0
+	if post.Count > 0 && !math.IsInf(variance, 1) && variance+tau2 > 0 {
+		b = variance / (variance + tau2)
+	}
+	v := (1-b)*variance + b*b*g.MeanScale*g.MeanScale
+	if b == 1 {
+		v = g.MeanScale*g.MeanScale + tau2 // A new draw from the group
+	}
+	return MeanPosterior{
+		Mean:  b*g.Mean + (1-b)*post.Mean,
+		Scale: math.Sqrt(v),
+		DF:    math.Inf(1),
+		Count: post.Count,
+	}
+}
+
+// posteriorVariance returns the variance of a Student-t posterior of the
+// mean, which is infinite with two or fewer degrees of freedom.
+func posteriorVariance(p MeanPosterior) float64 {
+	if math.IsInf(p.DF, 1) {
+		return p.Scale * p.Scale
+	}
+	if p.DF <= 2 {
+		return math.Inf(1)
+	}
+	return p.Scale * p.Scale * p.DF / (p.DF - 2)
+}
+
+// mean returns the arithmetic mean of xs.
+func mean(xs []float64) float64 {
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+// shrunkModel exposes one symbol of a HierarchicalModel as a Model.
+type shrunkModel struct {
+	h      *HierarchicalModel
+	symbol string
+}
+
+// Update feeds the symbol's own model.
+func (s *shrunkModel) Update(price float64) {
+	s.h.mux.Lock()
+	var target Model
+	for _, m := range s.h.groups[s.h.symbols[s.symbol]] {
+		if m.symbol == s.symbol {
+			target = m.model
+		}
+	}
+	s.h.mux.Unlock()
+	target.Update(price)
+}
+
+// Posterior implements Model.
+func (s *shrunkModel) Posterior() MeanPosterior {
+	post, _ := s.h.Shrunk(s.symbol)
+	return post
+}
+
+// ProbabilityAbove implements Model.
+func (s *shrunkModel) ProbabilityAbove(threshold float64) float64 {
+	return s.Posterior().ProbabilityAbove(threshold)
+}
+
+// CredibleInterval implements Model.
+func (s *shrunkModel) CredibleInterval(level float64) (lo, hi float64) {
+	return s.Posterior().CredibleInterval(level)
+}
@@ -0,0 +1,284 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// Regime is a hidden market state of the HMMRegimeModel.
+type Regime int
+
+const (
+	Bull Regime = iota
+	Bear
+	Sideways
+	numRegimes
+)
+
+// String returns the regime name.
+func (r Regime) String() string {
+	switch r {
+	case Bull:
+		return "bull"
+	case Bear:
+		return "bear"
+	case Sideways:
+		return "sideways"
+	}
+	return fmt.Sprintf("Regime(%d)", int(r))
+}
+
+// minRegimeStdDev keeps Baum-Welch from collapsing a regime onto a single return.
+const minRegimeStdDev = 1e-6
+
+// HMMRegimeModel is an observer of returns with a three-state hidden Markov
+// model with Gaussian emissions. Every update runs one step of the forward
+// filter, and Fit re-estimates the parameters with Baum-Welch.
+type HMMRegimeModel struct {
+	hmmParams
+	filtered [numRegimes]float64 // P(regime_t | returns so far)
+	started  bool
+	mux      sync.Mutex
+}
+
+// hmmParams are the parameters of the HMMRegimeModel.
+type hmmParams struct {
+	means      [numRegimes]float64
+	stdDevs    [numRegimes]float64
+	transition [numRegimes][numRegimes]float64
+	initial    [numRegimes]float64
+}
+
+// NewHMMRegimeModel creates a model with starting parameters for daily returns.
+func NewHMMRegimeModel() *HMMRegimeModel {
+	m := &HMMRegimeModel{hmmParams: hmmParams{
+		means:   [numRegimes]float64{Bull: 0.001, Bear: -0.0015, Sideways: 0},
+		stdDevs: [numRegimes]float64{Bull: 0.01, Bear: 0.02, Sideways: 0.005},
+		initial: [numRegimes]float64{1.0 / 3, 1.0 / 3, 1.0 / 3},
+	}}
+	for i := range m.transition {
+		for j := range m.transition[i] {
+			m.transition[i][j] = 0.01
+		}
+		m.transition[i][i] = 0.98
+	}
+	return m
+}
+
+// emission returns the density of return x in regime i.
+func (p *hmmParams) emission(i int, x float64) float64 {
+	z := (x - p.means[i]) / p.stdDevs[i]
+	return math.Exp(-z*z/2) / (p.stdDevs[i] * math.Sqrt(2*math.Pi))
+}
+
+// forward advances a filtered distribution by one observation and returns
+// the new distribution and the normalizing constant.
+func (p *hmmParams) forward(prev [numRegimes]float64, first bool, x float64) ([numRegimes]float64, float64) {
+	var prior, next [numRegimes]float64
+	var total float64
+	for j := range next {
+		if first {
+			prior[j] = p.initial[j]
+		} else {
+			for i := range prev {
+				prior[j] += prev[i] * p.transition[i][j]
+			}
+		}
+		next[j] = prior[j] * p.emission(j, x)
+		total += next[j]
+	}
+	if total == 0 {
+		// x is implausible under every regime: keep the predicted distribution
+		return prior, 0
+	}
+	for j := range next {
+		next[j] /= total
+	}
+	return next, total
+}
+
+// Update implements Observer.
+func (m *HMMRegimeModel) Update(ret float64) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.filtered, _ = m.forward(m.filtered, !m.started, ret)
+	m.started = true
+}
+
+// Probabilities returns the filtered probability of each regime.
+func (m *HMMRegimeModel) Probabilities() [numRegimes]float64 {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if !m.started {
+		return m.initial
+	}
+	return m.filtered
+}
+
+// MostLikely returns the regime with the highest filtered probability.
+func (m *HMMRegimeModel) MostLikely() Regime {
+	probs := m.Probabilities()
+	best := Bull
+	for r := range probs {
+		if probs[r] > probs[best] {
+			best = Regime(r)
+		}
+	}
+	return best
+}
+
+// Fit runs Baum-Welch on a history of returns for up to iterations rounds,
+// stopping early once the log-likelihood improves by less than 1e-8. States
+// are relabeled so the highest mean is Bull and the lowest Bear, and the
+// filter is rerun over the history so online updates continue from its end.
+// It returns the final log-likelihood. On error the model is unchanged.
+func (m *HMMRegimeModel) Fit(returns []float64, iterations int) (float64, error) {
+	T := len(returns)
+	if T < 2 {
+		return 0, fmt.Errorf("need at least 2 returns to fit, got %d", T)
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	p := m.hmmParams // Fitted in a copy, kept only on success
+
+	alpha := make([][numRegimes]float64, T)
+	beta := make([][numRegimes]float64, T)
+	scale := make([]float64, T)
+	logLik := math.Inf(-1)
+
+	for iter := 0; iter < iterations; iter++ {
+		// Scaled forward pass
+		for t, x := range returns {
+			var prev [numRegimes]float64
+			if t > 0 {
+				prev = alpha[t-1]
+			}
+			alpha[t], scale[t] = p.forward(prev, t == 0, x)
+			if scale[t] == 0 {
+				return logLik, fmt.Errorf("return %d (%g) has zero likelihood under every regime", t, x)
+			}
+		}
+		var ll float64
+		for _, c := range scale {
+			ll += math.Log(c)
+		}
+
+		// Scaled backward pass
+		for i := range beta[T-1] {
+			beta[T-1][i] = 1
+		}
+		for t := T - 2; t >= 0; t-- {
+			for i := range beta[t] {
+				var sum float64
+				for j := range beta[t] {
+					sum += p.transition[i][j] * p.emission(j, returns[t+1]) * beta[t+1][j]
+				}
+				beta[t][i] = sum / scale[t+1]
+			}
+		}
+
+		// Re-estimate from the state and transition posteriors
+		var gammaSum, weighted, weightedSq [numRegimes]float64
+		var xi [numRegimes][numRegimes]float64
+		for t, x := range returns {
+			for i := range gammaSum {
+				g := alpha[t][i] * beta[t][i]
+				if t == 0 {
+					p.initial[i] = g
+				}
+				gammaSum[i] += g
+				weighted[i] += g * x
+				weightedSq[i] += g * x * x
+				if t < T-1 {
+					for j := range xi[i] {
+						xi[i][j] += alpha[t][i] * p.transition[i][j] * p.emission(j, returns[t+1]) * beta[t+1][j] / scale[t+1]
+					}
+				}
+			}
+		}
+		for i := range p.means {
+			if gammaSum[i] == 0 {
+				continue
+			}
+			mean := weighted[i] / gammaSum[i]
+			p.means[i] = mean
+			p.stdDevs[i] = math.Max(minRegimeStdDev, math.Sqrt(math.Max(0, weightedSq[i]/gammaSum[i]-mean*mean)))
+			var rowSum float64
+			for j := range xi[i] {
+				rowSum += xi[i][j]
+			}
+			if rowSum > 0 {
+				for j := range xi[i] {
+					p.transition[i][j] = xi[i][j] / rowSum
+				}
+			}
+		}
+
+		improved := ll - logLik
+		logLik = ll
+		if improved < 1e-8 {
+			break
+		}
+	}
+
+	p.relabel()
+	m.hmmParams = p
+	m.filtered, m.started = [numRegimes]float64{}, false
+	for _, x := range returns {
+		m.filtered, _ = m.forward(m.filtered, !m.started, x)
+		m.started = true
+	}
+	return logLik, nil
+}
+
+// relabel permutes the states so that Bull has the highest mean, Bear the
+// lowest and Sideways the one in between.
+func (p *hmmParams) relabel() {
+	order := []int{0, 1, 2}
+	sort.Slice(order, func(a, b int) bool { return p.means[order[a]] > p.means[order[b]] })
+	// order[0] is the highest mean, order[2] the lowest
+	perm := [numRegimes]int{Bull: order[0], Sideways: order[1], Bear: order[2]}
+
+	means, stdDevs, initial, transition := p.means, p.stdDevs, p.initial, p.transition
+	for r, src := range perm {
+		p.means[r] = means[src]
+		p.stdDevs[r] = stdDevs[src]
+		p.initial[r] = initial[src]
+		for c, srcCol := range perm {
+			p.transition[r][c] = transition[src][srcCol]
+		}
+	}
+}
+
+// SignalGate can veto a trading signal, returning a reason when it does.
+type SignalGate interface {
+	Allow(signal int) (bool, string)
+}
+
+// BearGate returns a gate that suppresses buy signals while the bear regime
+// probability exceeds threshold.
+func (m *HMMRegimeModel) BearGate(threshold float64) SignalGate {
+	return bearGate{model: m, threshold: threshold}
+}
+
+// bearGate vetoes buys in a likely bear regime.
+type bearGate struct {
+	model     *HMMRegimeModel
+	threshold float64
+}
+
+// Allow implements SignalGate.
+func (g bearGate) Allow(signal int) (bool, string) {
+	if signal <= 0 {
+		return true, ""
+	}
+	if p := g.model.Probabilities()[Bear]; p > g.threshold {
+		return false, fmt.Sprintf("bear regime probability %.2f > %.2f", p, g.threshold)
+	}
+	return true, ""
+}
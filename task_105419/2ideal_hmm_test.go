@@ -0,0 +1,120 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// simulateRegimes draws n returns from a sticky three-regime Gaussian HMM.
+func simulateRegimes(rng *rand.Rand, n int, means, stdDevs [numRegimes]float64) []float64 {
+	returns := make([]float64, n)
+	state := Sideways
+	for i := range returns {
+		if rng.Float64() < 0.02 {
+			state = Regime(rng.Intn(int(numRegimes)))
+		}
+		returns[i] = means[state] + stdDevs[state]*rng.NormFloat64()
+	}
+	return returns
+}
+
+func TestHMMFit(t *testing.T) {
+	means := [numRegimes]float64{Bull: 0.003, Bear: -0.004, Sideways: 0}
+	stdDevs := [numRegimes]float64{Bull: 0.008, Bear: 0.03, Sideways: 0.002}
+	returns := simulateRegimes(rand.New(rand.NewSource(7)), 5000, means, stdDevs)
+
+	m := NewHMMRegimeModel()
+	before, err := m.Fit(returns, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := m.Fit(returns, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after < before {
+		t.Errorf("log-likelihood fell from %.2f to %.2f", before, after)
+	}
+
+	if !(m.means[Bear] < m.means[Sideways] && m.means[Sideways] < m.means[Bull]) {
+		t.Errorf("regimes not ordered by mean: %v", m.means)
+	}
+	for r := range stdDevs {
+		if got, want := m.stdDevs[r], stdDevs[r]; math.Abs(got-want) > 0.25*want {
+			t.Errorf("%v std dev = %.4f, want about %.4f", Regime(r), got, want)
+		}
+	}
+	for i, row := range m.transition {
+		var sum float64
+		for _, p := range row {
+			sum += p
+		}
+		if math.Abs(sum-1) > 1e-9 {
+			t.Errorf("transition row %d sums to %v", i, sum)
+		}
+	}
+
+	// The filter continues from the end of the history
+	for i := 0; i < 20; i++ {
+		m.Update(-0.06)
+	}
+	if got := m.MostLikely(); got != Bear {
+		t.Errorf("after a crash MostLikely() = %v, want bear", got)
+	}
+}
+
+func TestHMMFitNeedsHistory(t *testing.T) {
+	if _, err := NewHMMRegimeModel().Fit([]float64{0.01}, 10); err == nil {
+		t.Error("Fit with one return succeeded")
+	}
+}
+
+func TestHMMUpdateImplausibleReturn(t *testing.T) {
+	m := NewHMMRegimeModel()
+	m.Update(50) // Zero density under every regime
+	probs := m.Probabilities()
+	if probs != m.initial {
+		t.Errorf("after an implausible first return Probabilities() = %v, want the initial %v", probs, m.initial)
+	}
+
+	// The filter still responds to plausible returns afterwards
+	for i := 0; i < 10; i++ {
+		m.Update(-0.05)
+	}
+	m.Update(50)
+	probs = m.Probabilities()
+	if sum := probs[Bull] + probs[Bear] + probs[Sideways]; math.Abs(sum-1) > 1e-9 {
+		t.Errorf("probabilities sum to %v", sum)
+	}
+	if got := m.MostLikely(); got != Bear {
+		t.Errorf("MostLikely() = %v, want bear", got)
+	}
+	if ok, _ := m.BearGate(0.6).Allow(1); ok {
+		t.Error("bear gate allowed a buy")
+	}
+}
+
+func TestHMMFitErrorLeavesModel(t *testing.T) {
+	m := NewHMMRegimeModel()
+	for i := 0; i < 5; i++ {
+		m.Update(-0.03)
+	}
+	params, probs := m.hmmParams, m.Probabilities()
+
+	// The last return has zero likelihood under every regime
+	returns := simulateRegimes(rand.New(rand.NewSource(3)), 200, m.means, m.stdDevs)
+	returns = append(returns, 40)
+	if _, err := m.Fit(returns, 50); err == nil {
+		t.Fatal("Fit succeeded on a return with zero likelihood")
+	}
+	if m.hmmParams != params {
+		t.Errorf("failed Fit changed the parameters to %+v, want %+v", m.hmmParams, params)
+	}
+	if got := m.Probabilities(); got != probs {
+		t.Errorf("failed Fit changed the filter to %v, want %v", got, probs)
+	}
+}
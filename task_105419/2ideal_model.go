@@ -0,0 +1,178 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Model is a Bayesian model of the mean of an observed price series. Every
+// Model is an Observer, so any of them can be attached to a DataSource.
+type Model interface {
+	Observer
+	Posterior() MeanPosterior
+	ProbabilityAbove(threshold float64) float64
+	CredibleInterval(level float64) (lo, hi float64)
+}
+
+// MeanPosterior is the marginal posterior of the mean: a location-scale
+// Student-t with DF degrees of freedom (a normal when DF is +Inf).
+type MeanPosterior struct {
+	Mean  float64
+	Scale float64
+	DF    float64
+	Count int
+}
+
+// ProbabilityAbove returns the posterior probability that the mean exceeds threshold.
+func (p MeanPosterior) ProbabilityAbove(threshold float64) float64 {
+	return 1 - studentTCDF((threshold-p.Mean)/p.Scale, p.DF)
+}
+
+// CredibleInterval returns the central credible interval holding level of the posterior mass.
+func (p MeanPosterior) CredibleInterval(level float64) (lo, hi float64) {
+	q := studentTQuantile(0.5+level/2, p.DF)
+	return p.Mean - q*p.Scale, p.Mean + q*p.Scale
+}
+
+// meanPosterior returns the Student-t marginal of the mean under a NIG posterior.
+func (p NIGParams) meanPosterior(count int) MeanPosterior {
+	return MeanPosterior{
+		Mean:  p.Mu,
+		Scale: math.Sqrt(p.Beta / (p.Alpha * p.Kappa)),
+		DF:    2 * p.Alpha,
+		Count: count,
+	}
+}
+
+// decide turns a model's posterior into a buy (1), sell (-1) or hold (0)
+// decision at now, recording the evidence behind it.
+func decide(m Model, threshold, confidence float64, now time.Time) Decision {
+	post := m.Posterior()
+	d := Decision{
+		Time:        now,
+		Probability: post.ProbabilityAbove(threshold),
+		Mean:        post.Mean,
+		Threshold:   threshold,
+		Confidence:  confidence,
+		Count:       post.Count,
+	}
+	d.Lower, d.Upper = post.CredibleInterval(decisionCredibleLevel)
+	if d.Probability > confidence {
+		d.Signal = 1 // Buy signal
+	} else if d.Probability < 1-confidence {
+		d.Signal = -1 // Sell signal
+	}
+	return d
+}
+
+// NormalKnownVarianceModel is a Normal prior on the mean with a known
+// observation standard deviation.
+type NormalKnownVarianceModel struct {
+	sigma    float64 // Known observation standard deviation
+	mean     float64 // Posterior mean of the mean
+	variance float64 // Posterior variance of the mean
+	count    int
+	mux      sync.Mutex
+}
+
+// NewNormalKnownVarianceModel creates a model with prior N(mu0, tau0^2) on the
+// mean and observation noise sigma.
+func NewNormalKnownVarianceModel(mu0, tau0, sigma float64) *NormalKnownVarianceModel {
+	return &NormalKnownVarianceModel{
+		sigma:    sigma,
+		mean:     mu0,
+		variance: tau0 * tau0,
+	}
+}
+
+// Update combines the prior and the new observation by precision weighting.
+func (m *NormalKnownVarianceModel) Update(price float64) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	priorPrec := 1 / m.variance
+	obsPrec := 1 / (m.sigma * m.sigma)
+	m.variance = 1 / (priorPrec + obsPrec)
+	m.mean = m.variance * (priorPrec*m.mean + obsPrec*price)
+	m.count++
+}
+
+// Posterior implements Model.
+func (m *NormalKnownVarianceModel) Posterior() MeanPosterior {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return MeanPosterior{
+		Mean:  m.mean,
+		Scale: math.Sqrt(m.variance),
+		DF:    math.Inf(1),
+		Count: m.count,
+	}
+}
+
+// ProbabilityAbove implements Model.
+func (m *NormalKnownVarianceModel) ProbabilityAbove(threshold float64) float64 {
+	return m.Posterior().ProbabilityAbove(threshold)
+}
+
+// CredibleInterval implements Model.
+func (m *NormalKnownVarianceModel) CredibleInterval(level float64) (lo, hi float64) {
+	return m.Posterior().CredibleInterval(level)
+}
+
+// StudentTModel is a robust model with a Student-t likelihood of nu degrees of
+// freedom. Each observation enters a NIG posterior with a weight that shrinks
+// as it moves into the tails, so single outliers barely move the mean.
+type StudentTModel struct {
+	nu    float64
+	post  NIGParams
+	count int
+	mux   sync.Mutex
+}
+
+// NewStudentTModel creates a robust model starting from the given prior.
+func NewStudentTModel(prior NIGParams, nu float64) *StudentTModel {
+	return &StudentTModel{
+		nu:   nu,
+		post: prior,
+	}
+}
+
+// Update applies a weighted conjugate update, using the Student-t scale
+// mixture weight of the new observation under the current posterior.
+func (m *StudentTModel) Update(price float64) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	p := m.post
+	d := price - p.Mu
+	w := (m.nu + 1) / (m.nu + d*d*p.Alpha/p.Beta)
+	kappa := p.Kappa + w
+	m.post = NIGParams{
+		Mu:    (p.Kappa*p.Mu + w*price) / kappa,
+		Kappa: kappa,
+		Alpha: p.Alpha + 0.5,
+		Beta:  p.Beta + p.Kappa*w*d*d/(2*kappa),
+	}
+	m.count++
+}
+
+// Posterior implements Model.
+func (m *StudentTModel) Posterior() MeanPosterior {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.post.meanPosterior(m.count)
+}
+
+// ProbabilityAbove implements Model.
+func (m *StudentTModel) ProbabilityAbove(threshold float64) float64 {
+	return m.Posterior().ProbabilityAbove(threshold)
+}
+
+// CredibleInterval implements Model.
+func (m *StudentTModel) CredibleInterval(level float64) (lo, hi float64) {
+	return m.Posterior().CredibleInterval(level)
+}
@@ -0,0 +1,230 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CorrectionMethod selects how a PortfolioDecider controls false signals
+// across many symbols.
+type CorrectionMethod int
+
+const (
+	BayesianFDR       CorrectionMethod = iota // Largest set with posterior expected FDR within the level
+	Bonferroni                                // Each symbol tested at level/m
+	BenjaminiHochberg                         // Step-up procedure on posterior tail probabilities
+)
+
+// ParseCorrectionMethod parses "bayes", "bonferroni" or "bh".
+func ParseCorrectionMethod(s string) (CorrectionMethod, error) {
+	switch s {
+	case "bayes":
+		return BayesianFDR, nil
+	case "bonferroni":
+		return Bonferroni, nil
+	case "bh":
+		return BenjaminiHochberg, nil
+	}
+	return 0, fmt.Errorf("unknown correction method %q", s)
+}
+
+// String returns the name accepted by ParseCorrectionMethod.
+func (m CorrectionMethod) String() string {
+	switch m {
+	case BayesianFDR:
+		return "bayes"
+	case Bonferroni:
+		return "bonferroni"
+	case BenjaminiHochberg:
+		return "bh"
+	}
+	return fmt.Sprintf("CorrectionMethod(%d)", int(m))
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (m CorrectionMethod) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// PortfolioDecision is the set of signals emitted across all symbols after
+// multiple-testing correction.
+type PortfolioDecision struct {
+	Time        time.Time        `json:"time"` // Time of the latest decision
+	Method      CorrectionMethod `json:"method"`
+	Level       float64          `json:"level"`
+	Tested      int              `json:"tested"`
+	Discoveries int              `json:"discoveries"`
+	ExpectedFDR float64          `json:"expected_fdr"` // Posterior expected share of wrong signals among those emitted
+	Decisions   []Decision       `json:"decisions"`    // Latest decision per symbol, sorted by symbol
+}
+
+// PortfolioDecider collects the latest per-symbol decision and only lets
+// signals through that survive a correction for testing every symbol at once.
+// It never turns a hold into a signal, and a signal that survives keeps the
+// direction of the incoming Decision. A symbol's local false discovery rate
+// is the posterior probability of the less likely side of its threshold,
+// min(P, 1-P).
+type PortfolioDecider struct {
+	method    CorrectionMethod
+	level     float64
+	latest    map[string]Decision
+	listeners []func(PortfolioDecision)
+	mux       sync.Mutex
+}
+
+// NewPortfolioDecider creates a decider controlling false signals at level
+// (for example 0.05) with the given method.
+func NewPortfolioDecider(method CorrectionMethod, level float64) *PortfolioDecider {
+	return &PortfolioDecider{
+		method: method,
+		level:  level,
+		latest: make(map[string]Decision),
+	}
+}
+
+// Subscribe registers a function called with the corrected portfolio after every decision.
+func (p *PortfolioDecider) Subscribe(fn func(PortfolioDecision)) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.listeners = append(p.listeners, fn)
+}
+
+// Record replaces the latest decision for d.Symbol, typically once per tick.
+func (p *PortfolioDecider) Record(d Decision) {
+	p.mux.Lock()
+	p.latest[d.Symbol] = d
+	listeners := slices.Clone(p.listeners)
+	var pd PortfolioDecision
+	if len(listeners) > 0 {
+		pd = p.decide()
+	}
+	p.mux.Unlock()
+
+	for _, fn := range listeners {
+		fn(pd)
+	}
+}
+
+// Correct records d like Record and returns it as corrected across the
+// portfolio: unchanged, or with its signal suppressed.
+func (p *PortfolioDecider) Correct(d Decision) Decision {
+	p.mux.Lock()
+	p.latest[d.Symbol] = d
+	listeners := slices.Clone(p.listeners)
+	pd := p.decide()
+	p.mux.Unlock()
+
+	for _, fn := range listeners {
+		fn(pd)
+	}
+	i, _ := slices.BinarySearchFunc(pd.Decisions, d.Symbol, func(x Decision, symbol string) int {
+		return strings.Compare(x.Symbol, symbol)
+	})
+	return pd.Decisions[i]
+}
+
+// Decide applies the correction to the latest decision of every symbol.
+func (p *PortfolioDecider) Decide() PortfolioDecision {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.decide()
+}
+
+func (p *PortfolioDecider) decide() PortfolioDecision {
+	symbols := make([]string, 0, len(p.latest))
+	for s := range p.latest {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+
+	lfdr := make([]float64, len(symbols))
+	for i, s := range symbols {
+		prob := p.latest[s].Probability
+		lfdr[i] = math.Min(prob, 1-prob)
+	}
+	selected := selectDiscoveries(p.method, p.level, lfdr)
+
+	pd := PortfolioDecision{
+		Method:    p.method,
+		Level:     p.level,
+		Tested:    len(symbols),
+		Decisions: make([]Decision, len(symbols)),
+	}
+	var wrong float64
+	for i, s := range symbols {
+		d := p.latest[s]
+		if d.Time.After(pd.Time) {
+			pd.Time = d.Time
+		}
+		switch {
+		case d.Signal == 0:
+			// The correction only removes signals, it never adds them
+		case selected[i]:
+			pd.Discoveries++
+			wrong += lfdr[i]
+		default:
+			d.Signal = 0
+			d.Suppressed = fmt.Sprintf("not significant under %s correction at %g across %d symbols", p.method, p.level, len(symbols))
+		}
+		pd.Decisions[i] = d
+	}
+	if pd.Discoveries > 0 {
+		pd.ExpectedFDR = wrong / float64(pd.Discoveries)
+	}
+	return pd
+}
+
+// selectDiscoveries reports which hypotheses to reject given each one's
+// posterior probability of being wrong. The frequentist corrections use the
+// equal-tailed posterior tail probability 2*lfdr in place of a p-value.
+func selectDiscoveries(method CorrectionMethod, level float64, lfdr []float64) []bool {
+	m := len(lfdr)
+	selected := make([]bool, m)
+	if m == 0 {
+		return selected
+	}
+	order := make([]int, m)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return lfdr[order[a]] < lfdr[order[b]] })
+
+	k := 0 // Number of rejections, taken from the front of order
+	switch method {
+	case BayesianFDR:
+		// The running mean of sorted lfdr values never decreases
+		var sum float64
+		for i, j := range order {
+			sum += lfdr[j]
+			if sum/float64(i+1) > level {
+				break
+			}
+			k = i + 1
+		}
+	case Bonferroni:
+		for _, j := range order {
+			if 2*lfdr[j] > level/float64(m) {
+				break
+			}
+			k++
+		}
+	case BenjaminiHochberg:
+		for i, j := range order {
+			if 2*lfdr[j] <= float64(i+1)*level/float64(m) {
+				k = i + 1
+			}
+		}
+	}
+	for _, j := range order[:k] {
+		selected[j] = true
+	}
+	return selected
+}
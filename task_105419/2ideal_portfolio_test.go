@@ -0,0 +1,85 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSelectDiscoveries(t *testing.T) {
+	tests := []struct {
+		method CorrectionMethod
+		lfdr   []float64
+		want   []bool
+	}{
+		// Running mean of sorted lfdr: 0.001, 0.0015, 0.0143, 0.0858
+		{BayesianFDR, []float64{0.001, 0.002, 0.3, 0.04}, []bool{true, true, false, true}},
+		// 2*lfdr against 0.05/4
+		{Bonferroni, []float64{0.001, 0.002, 0.3, 0.04}, []bool{true, true, false, false}},
+		{Bonferroni, []float64{0.005, 0.011, 0.4}, []bool{true, false, false}},
+		// 2*lfdr = 0.01, 0.022, 0.8 against 0.0167, 0.0333, 0.05
+		{BenjaminiHochberg, []float64{0.005, 0.011, 0.4}, []bool{true, true, false}},
+		// Step-up: the second smallest passes its bound even though the smallest fails its own
+		{BenjaminiHochberg, []float64{0.0125, 0.0124}, []bool{true, true}},
+		{BayesianFDR, []float64{0.2, 0.3}, []bool{false, false}},
+		{BayesianFDR, nil, []bool{}},
+	}
+	for _, tt := range tests {
+		got := selectDiscoveries(tt.method, 0.05, tt.lfdr)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("selectDiscoveries(%v, %v) = %v, want %v", tt.method, tt.lfdr, got, tt.want)
+		}
+	}
+}
+
+func TestPortfolioNeverAddsSignals(t *testing.T) {
+	p := NewPortfolioDecider(BayesianFDR, 0.05)
+	for i := 0; i < 20; i++ {
+		p.Record(Decision{Symbol: fmt.Sprintf("S%02d", i), Signal: 1, Probability: 0.999})
+	}
+	// Below the symbol's own confidence, but cheap to add to a large set of discoveries
+	hold := p.Correct(Decision{Symbol: "HOLD", Signal: 0, Probability: 0.88})
+	if hold.Signal != 0 {
+		t.Errorf("hold became signal %d", hold.Signal)
+	}
+
+	pd := p.Decide()
+	if pd.Discoveries != 20 {
+		t.Errorf("Discoveries = %d, want 20", pd.Discoveries)
+	}
+	for _, d := range pd.Decisions {
+		if want := map[bool]int{true: 0, false: 1}[d.Symbol == "HOLD"]; d.Signal != want {
+			t.Errorf("%s signal = %d, want %d", d.Symbol, d.Signal, want)
+		}
+	}
+}
+
+func TestPortfolioSuppressesWeakSignals(t *testing.T) {
+	p := NewPortfolioDecider(Bonferroni, 0.05)
+	for i := 0; i < 10; i++ {
+		p.Record(Decision{Symbol: fmt.Sprintf("S%02d", i), Signal: 1, Probability: 0.97})
+	}
+	strong := p.Correct(Decision{Symbol: "STRONG", Signal: -1, Probability: 0.0001})
+	if strong.Signal != -1 {
+		t.Errorf("strong sell corrected to %d, want -1", strong.Signal)
+	}
+	for _, d := range p.Decide().Decisions {
+		if d.Symbol != "STRONG" && (d.Signal != 0 || d.Suppressed == "") {
+			t.Errorf("%s: signal %d suppressed %q, want a suppressed hold", d.Symbol, d.Signal, d.Suppressed)
+		}
+	}
+}
+
+func TestPortfolioTimeIsLatestDecision(t *testing.T) {
+	p := NewPortfolioDecider(BayesianFDR, 0.05)
+	latest := time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC)
+	p.Record(Decision{Symbol: "A", Time: latest})
+	p.Record(Decision{Symbol: "B", Time: latest.Add(-time.Hour)})
+	if got := p.Decide().Time; !got.Equal(latest) {
+		t.Errorf("portfolio time %v, want the latest decision's %v", got, latest)
+	}
+}
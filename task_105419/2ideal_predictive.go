@@ -0,0 +1,151 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// PosteriorSample is one joint draw of the mean and variance from the posterior.
+type PosteriorSample struct {
+	Mean     float64
+	Variance float64
+}
+
+// SetRand sets the random source used for sampling. Pass a seeded source to
+// make SamplePosterior and SimulatePaths reproducible; without one a
+// time-seeded source is created on first use.
+func (e *BayesianEstimator) SetRand(rng *rand.Rand) {
+	e.rngMux.Lock()
+	defer e.rngMux.Unlock()
+	e.rng = rng
+}
+
+// withRand runs fn with the estimator's random source held exclusively.
+func (e *BayesianEstimator) withRand(fn func(rng *rand.Rand)) {
+	e.rngMux.Lock()
+	defer e.rngMux.Unlock()
+	if e.rng == nil {
+		e.rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	fn(e.rng)
+}
+
+// sample draws sigma^2 ~ InvGamma(Alpha, Beta), then mean ~ N(Mu, sigma^2/Kappa).
+func (p NIGParams) sample(rng *rand.Rand) PosteriorSample {
+	variance := p.Beta / sampleGamma(rng, p.Alpha)
+	return PosteriorSample{
+		Mean:     p.Mu + rng.NormFloat64()*math.Sqrt(variance/p.Kappa),
+		Variance: variance,
+	}
+}
+
+// SamplePosterior draws n samples of the mean and variance from the posterior.
+func (e *BayesianEstimator) SamplePosterior(n int) []PosteriorSample {
+	post := e.Params()
+	samples := make([]PosteriorSample, n)
+	e.withRand(func(rng *rand.Rand) {
+		for i := range samples {
+			samples[i] = post.sample(rng)
+		}
+	})
+	return samples
+}
+
+// SimulatePaths simulates n paths of the next horizon observations. Each path
+// draws its own mean and variance from the posterior, so the spread across
+// paths includes parameter uncertainty as well as noise. When the estimator is
+// fed returns, CompoundPaths turns the result into price paths.
+func (e *BayesianEstimator) SimulatePaths(horizon, n int) [][]float64 {
+	post := e.Params()
+	paths := make([][]float64, n)
+	e.withRand(func(rng *rand.Rand) {
+		for i := range paths {
+			s := post.sample(rng)
+			sd := math.Sqrt(s.Variance)
+			path := make([]float64, horizon)
+			for t := range path {
+				path[t] = s.Mean + sd*rng.NormFloat64()
+			}
+			paths[i] = path
+		}
+	})
+	return paths
+}
+
+// CompoundPaths converts simulated return paths into price paths starting from start.
+func CompoundPaths(start float64, kind ReturnKind, returns [][]float64) [][]float64 {
+	prices := make([][]float64, len(returns))
+	for i, path := range returns {
+		p := start
+		prices[i] = make([]float64, len(path))
+		for t, r := range path {
+			if kind == LogReturns {
+				p *= math.Exp(r)
+			} else {
+				p *= 1 + r
+			}
+			prices[i][t] = p
+		}
+	}
+	return prices
+}
+
+// RiskReport summarizes the predictive distribution of profit and loss.
+type RiskReport struct {
+	Level     float64 // Confidence level, e.g. 0.99
+	VaR       float64 // Loss exceeded with probability 1-Level
+	ES        float64 // Mean loss beyond VaR
+	Quantiles map[float64]float64
+}
+
+// PredictiveRisk computes VaR, expected shortfall and P&L quantiles at the
+// end of simulated price paths for a position of size units bought at start.
+func PredictiveRisk(start, size float64, paths [][]float64, level float64) RiskReport {
+	pnl := make([]float64, 0, len(paths))
+	for _, path := range paths {
+		if len(path) > 0 {
+			pnl = append(pnl, size*(path[len(path)-1]-start))
+		}
+	}
+	sort.Float64s(pnl)
+
+	r := RiskReport{Level: level, Quantiles: make(map[float64]float64)}
+	if len(pnl) == 0 {
+		return r
+	}
+	for _, q := range []float64{0.01, 0.05, 0.5, 0.95, 0.99} {
+		r.Quantiles[q] = sortedQuantile(pnl, q)
+	}
+	r.VaR = -sortedQuantile(pnl, 1-level)
+	var tail float64
+	k := 0
+	for _, x := range pnl {
+		if -x < r.VaR {
+			break
+		}
+		tail += x
+		k++
+	}
+	if k > 0 {
+		r.ES = -tail / float64(k)
+	} else {
+		r.ES = r.VaR
+	}
+	return r
+}
+
+// sortedQuantile returns the q-quantile of sorted xs by linear interpolation.
+func sortedQuantile(xs []float64, q float64) float64 {
+	pos := q * float64(len(xs)-1)
+	i := int(pos)
+	if i >= len(xs)-1 {
+		return xs[len(xs)-1]
+	}
+	frac := pos - float64(i)
+	return xs[i]*(1-frac) + xs[i+1]*frac
+}
@@ -0,0 +1,270 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// PriorMethod selects how FitPrior estimates prior hyperparameters.
+type PriorMethod int
+
+const (
+	MomentsPrior            PriorMethod = iota // Match the spread of block means and variances
+	MarginalLikelihoodPrior                    // Maximize the evidence of the blocks
+)
+
+// ParsePriorMethod parses "moments" or "ml".
+func ParsePriorMethod(s string) (PriorMethod, error) {
+	switch s {
+	case "moments":
+		return MomentsPrior, nil
+	case "ml":
+		return MarginalLikelihoodPrior, nil
+	}
+	return 0, fmt.Errorf("unknown prior method %q", s)
+}
+
+// SplitBlocks cuts xs into consecutive blocks of size observations, dropping
+// a shorter remainder.
+func SplitBlocks(xs []float64, size int) [][]float64 {
+	var blocks [][]float64
+	for i := 0; size > 0 && i+size <= len(xs); i += size {
+		blocks = append(blocks, xs[i:i+size])
+	}
+	return blocks
+}
+
+// FitPrior fits Normal-Inverse-Gamma hyperparameters by empirical Bayes.
+// Each block is treated as an independent draw of (mean, variance) from the
+// prior: consecutive windows of one series, or the histories of comparable
+// symbols. The fitted prior says how much the mean and variance move between
+// blocks, so an estimator started from it adapts as fast as history suggests.
+// Kappa is capped at the number of observations and Alpha at half of it, so a
+// prior never counts for more than the history it was fitted to. Constant
+// blocks are rejected: their evidence grows without bound as Beta shrinks.
+func FitPrior(blocks [][]float64, method PriorMethod) (NIGParams, error) {
+	if len(blocks) < 2 {
+		return NIGParams{}, fmt.Errorf("need at least 2 blocks, got %d", len(blocks))
+	}
+	for i, b := range blocks {
+		if len(b) < 2 {
+			return NIGParams{}, errors.New("blocks need at least 2 observations")
+		}
+		// A constant block has unbounded evidence as Beta goes to 0
+		if _, v := meanVariance(b); !(v > 0) {
+			return NIGParams{}, fmt.Errorf("block %d has no variance", i)
+		}
+	}
+	prior, err := momentsPrior(blocks)
+	if err != nil {
+		return NIGParams{}, err
+	}
+	if method == MarginalLikelihoodPrior {
+		prior = maxEvidencePrior(blocks, prior)
+	}
+	if err := prior.validate(); err != nil {
+		return NIGParams{}, fmt.Errorf("degenerate prior: %w", err)
+	}
+	return prior, nil
+}
+
+// NewEmpiricalBayesEstimator creates an estimator whose prior is fitted to
+// history cut into blocks of blockSize observations. history is only used for
+// the prior; the estimator has seen no data.
+func NewEmpiricalBayesEstimator(history []float64, blockSize int, method PriorMethod) (*BayesianEstimator, error) {
+	prior, err := FitPrior(SplitBlocks(history, blockSize), method)
+	if err != nil {
+		return nil, err
+	}
+	return NewBayesianEstimator(prior), nil
+}
+
+// momentsPrior matches the prior to the spread of the blocks' sample means and
+// variances, after removing the part explained by sampling noise. Under the
+// prior E[sigma^2] = Beta/(Alpha-1), Var(sigma^2) = E[sigma^2]^2/(Alpha-2) and
+// Var(mean) = E[sigma^2]/Kappa. Spreads that sampling noise fully explains
+// give the strongest prior the data supports.
+func momentsPrior(blocks [][]float64) (NIGParams, error) {
+	var means, vars []float64
+	for _, b := range blocks {
+		m, v := meanVariance(b)
+		means = append(means, m)
+		vars = append(vars, v)
+	}
+	maxStrength := totalCount(blocks)
+	size := maxStrength / float64(len(blocks)) // Average block size
+	mu, meansVar := meanVariance(means)
+	m2, varsVar := meanVariance(vars)
+	if !(m2 > 0) || math.IsInf(m2, 1) {
+		return NIGParams{}, fmt.Errorf("pooled variance %v must be positive and finite", m2)
+	}
+
+	// Var(s^2) = Var(sigma^2)(1 + 2/(n-1)) + 2 E[sigma^2]^2/(n-1) for normal data
+	sigmaVar := (varsVar - 2*m2*m2/(size-1)) / (1 + 2/(size-1))
+	alpha := maxStrength / 2
+	if sigmaVar > 0 {
+		alpha = math.Min(alpha, 2+m2*m2/sigmaVar)
+	}
+
+	// Var(block mean) = E[sigma^2]/Kappa + E[sigma^2]/n
+	kappa := maxStrength
+	if inv := meansVar/m2 - 1/size; inv > 0 {
+		kappa = math.Min(kappa, 1/inv)
+	}
+	return NIGParams{Mu: mu, Kappa: kappa, Alpha: alpha, Beta: m2 * (alpha - 1)}, nil
+}
+
+// maxEvidencePrior maximizes the summed log evidence of the blocks with
+// Nelder-Mead, over the mean and the logs of Kappa, Alpha-1 and Beta,
+// starting from start. Alpha stays above 1 so the prior variance is finite.
+func maxEvidencePrior(blocks [][]float64, start NIGParams) NIGParams {
+	maxStrength := totalCount(blocks)
+	toParams := func(x []float64) NIGParams {
+		return NIGParams{
+			Mu:    x[0],
+			Kappa: math.Min(math.Exp(x[1]), maxStrength),
+			Alpha: math.Min(1+math.Exp(x[2]), maxStrength/2),
+			Beta:  math.Exp(x[3]),
+		}
+	}
+	negEvidence := func(x []float64) float64 {
+		p := toParams(x)
+		var sum float64
+		for _, b := range blocks {
+			sum += p.logEvidence(b)
+		}
+		if math.IsNaN(sum) {
+			return math.Inf(1)
+		}
+		return -sum
+	}
+	x0 := []float64{start.Mu, math.Log(start.Kappa), math.Log(start.Alpha - 1), math.Log(start.Beta)}
+	// Step the mean by about one prior standard deviation of it
+	step := []float64{math.Sqrt(start.Beta / ((start.Alpha - 1) * start.Kappa)), 0.5, 0.5, 0.5}
+	if !(step[0] > 0) || math.IsInf(step[0], 0) {
+		step[0] = math.Max(1e-8, 0.1*math.Abs(start.Mu))
+	}
+	return toParams(nelderMead(negEvidence, x0, step, 5000))
+}
+
+// totalCount returns the number of observations in blocks.
+func totalCount(blocks [][]float64) float64 {
+	n := 0
+	for _, b := range blocks {
+		n += len(b)
+	}
+	return float64(n)
+}
+
+// logEvidence returns the log marginal likelihood of xs under the NIG prior p.
+func (p NIGParams) logEvidence(xs []float64) float64 {
+	post := p
+	for _, x := range xs {
+		post = post.update(x)
+	}
+	la, _ := math.Lgamma(post.Alpha)
+	lb, _ := math.Lgamma(p.Alpha)
+	return la - lb + p.Alpha*math.Log(p.Beta) - post.Alpha*math.Log(post.Beta) +
+		0.5*(math.Log(p.Kappa)-math.Log(post.Kappa)) - 0.5*float64(len(xs))*math.Log(2*math.Pi)
+}
+
+// meanVariance returns the mean and unbiased variance of xs.
+func meanVariance(xs []float64) (mean, variance float64) {
+	for _, x := range xs {
+		mean += x
+	}
+	mean /= float64(len(xs))
+	for _, x := range xs {
+		variance += (x - mean) * (x - mean)
+	}
+	return mean, variance / float64(len(xs)-1)
+}
+
+// nelderMead minimizes f from x0, with initial simplex steps step, for at
+// most iterations iterations.
+func nelderMead(f func([]float64) float64, x0, step []float64, iterations int) []float64 {
+	n := len(x0)
+	simplex := make([][]float64, n+1)
+	values := make([]float64, n+1)
+	for i := range simplex {
+		simplex[i] = slices.Clone(x0)
+		if i > 0 {
+			simplex[i][i-1] += step[i-1]
+		}
+		values[i] = f(simplex[i])
+	}
+	point := func(c []float64, towards []float64, t float64) []float64 {
+		p := make([]float64, n)
+		for j := range p {
+			p[j] = c[j] + t*(towards[j]-c[j])
+		}
+		return p
+	}
+
+	for iter := 0; iter < iterations; iter++ {
+		// Order best to worst
+		for i := 1; i <= n; i++ {
+			for j := i; j > 0 && values[j] < values[j-1]; j-- {
+				simplex[j], simplex[j-1] = simplex[j-1], simplex[j]
+				values[j], values[j-1] = values[j-1], values[j]
+			}
+		}
+		if math.Abs(values[n]-values[0]) <= 1e-10*(1+math.Abs(values[0])) {
+			break
+		}
+		centroid := make([]float64, n)
+		for _, s := range simplex[:n] {
+			for j := range centroid {
+				centroid[j] += s[j] / float64(n)
+			}
+		}
+		worst := simplex[n]
+
+		reflected := point(centroid, worst, -1)
+		fr := f(reflected)
+		switch {
+		case fr < values[0]:
+			expanded := point(centroid, worst, -2)
+			if fe := f(expanded); fe < fr {
+				simplex[n], values[n] = expanded, fe
+			} else {
+				simplex[n], values[n] = reflected, fr
+			}
+		case fr < values[n-1]:
+			simplex[n], values[n] = reflected, fr
+		default:
+			contracted := point(centroid, worst, 0.5)
+			if fc := f(contracted); fc < values[n] {
+				simplex[n], values[n] = contracted, fc
+			} else {
+				// Shrink towards the best point
+				for i := 1; i <= n; i++ {
+					simplex[i] = point(simplex[0], simplex[i], 0.5)
+					values[i] = f(simplex[i])
+				}
+			}
+		}
+	}
+	best := 0
+	for i := range values {
+		if values[i] < values[best] {
+			best = i
+		}
+	}
+	return simplex[best]
+}
+
+// collector is an Observer that records every value it receives.
+type collector struct {
+	values []float64
+}
+
+// Update implements Observer.
+func (c *collector) Update(x float64) {
+	c.values = append(c.values, x)
+}
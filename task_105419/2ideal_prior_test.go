@@ -0,0 +1,90 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// drawBlocks draws each block's mean and variance from the prior p, then
+// size normal observations from them.
+func drawBlocks(rng *rand.Rand, p NIGParams, blocks, size int) [][]float64 {
+	out := make([][]float64, blocks)
+	for i := range out {
+		variance := p.Beta / sampleGamma(rng, p.Alpha)
+		mean := p.Mu + math.Sqrt(variance/p.Kappa)*rng.NormFloat64()
+		for j := 0; j < size; j++ {
+			out[i] = append(out[i], mean+math.Sqrt(variance)*rng.NormFloat64())
+		}
+	}
+	return out
+}
+
+func TestFitPriorRecoversPrior(t *testing.T) {
+	truth := NIGParams{Mu: 0.001, Kappa: 20, Alpha: 6, Beta: 5 * 4e-4}
+	blocks := drawBlocks(rand.New(rand.NewSource(3)), truth, 400, 50)
+	wantVar := truth.Beta / (truth.Alpha - 1)
+
+	for _, method := range []PriorMethod{MomentsPrior, MarginalLikelihoodPrior} {
+		got, err := FitPrior(blocks, method)
+		if err != nil {
+			t.Fatalf("method %d: %v", method, err)
+		}
+		if v := got.Beta / (got.Alpha - 1); math.Abs(v/wantVar-1) > 0.1 {
+			t.Errorf("method %d: E[sigma^2] = %v, want about %v", method, v, wantVar)
+		}
+		if math.Abs(got.Mu-truth.Mu) > 0.002 {
+			t.Errorf("method %d: Mu = %v, want about %v", method, got.Mu, truth.Mu)
+		}
+		if got.Kappa < truth.Kappa/2 || got.Kappa > truth.Kappa*2 {
+			t.Errorf("method %d: Kappa = %v, want about %v", method, got.Kappa, truth.Kappa)
+		}
+		if got.Alpha < truth.Alpha/2 || got.Alpha > truth.Alpha*2 {
+			t.Errorf("method %d: Alpha = %v, want about %v", method, got.Alpha, truth.Alpha)
+		}
+	}
+}
+
+func TestFitPriorCapsStrength(t *testing.T) {
+	// Every block comes from one normal, so nothing moves between blocks and
+	// only the caps bound the prior.
+	rng := rand.New(rand.NewSource(4))
+	xs := make([]float64, 200)
+	for i := range xs {
+		xs[i] = 0.01 * rng.NormFloat64()
+	}
+	blocks := SplitBlocks(xs, 20)
+	for _, method := range []PriorMethod{MomentsPrior, MarginalLikelihoodPrior} {
+		got, err := FitPrior(blocks, method)
+		if err != nil {
+			t.Fatalf("method %d: %v", method, err)
+		}
+		if got.Kappa > 200 || got.Alpha > 100 || !(got.Alpha > 1) {
+			t.Errorf("method %d: %+v, want Kappa <= 200 and 1 < Alpha <= 100", method, got)
+		}
+	}
+}
+
+func TestFitPriorRejectsDegenerateHistory(t *testing.T) {
+	flat := make([]float64, 200)
+	nearFlat := make([]float64, 200)
+	nearFlat[57] = 1e-12
+	for _, tc := range []struct {
+		name   string
+		blocks [][]float64
+	}{
+		{"one block", SplitBlocks(nearFlat, 200)},
+		{"short blocks", SplitBlocks(nearFlat, 1)},
+		{"flat", SplitBlocks(flat, 20)},
+		{"near flat", SplitBlocks(nearFlat, 20)},
+	} {
+		for _, method := range []PriorMethod{MomentsPrior, MarginalLikelihoodPrior} {
+			if p, err := FitPrior(tc.blocks, method); err == nil {
+				t.Errorf("%s, method %d: fitted %+v", tc.name, method, p)
+			}
+		}
+	}
+}
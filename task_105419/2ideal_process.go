@@ -0,0 +1,114 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// PriceProcess is a generative model of synthetic prices. Next returns the
+// price one tick after price, drawing randomness only from rng.
+type PriceProcess interface {
+	Next(rng *rand.Rand, price float64) float64
+}
+
+// NoiseProcess draws independent normal prices around a fixed level.
+type NoiseProcess struct {
+	Mean, StdDev float64
+}
+
+// Next implements PriceProcess.
+func (p NoiseProcess) Next(rng *rand.Rand, price float64) float64 {
+	return p.Mean + p.StdDev*rng.NormFloat64()
+}
+
+// ArithmeticProcess is a random walk with constant drift and volatility per tick.
+type ArithmeticProcess struct {
+	Drift, Volatility float64
+}
+
+// Next implements PriceProcess.
+func (p ArithmeticProcess) Next(rng *rand.Rand, price float64) float64 {
+	return price + p.Drift + p.Volatility*rng.NormFloat64()
+}
+
+// GBMProcess is geometric Brownian motion with drift and volatility per tick.
+type GBMProcess struct {
+	Drift, Volatility float64
+}
+
+// Next implements PriceProcess.
+func (p GBMProcess) Next(rng *rand.Rand, price float64) float64 {
+	return price * math.Exp(p.Drift-p.Volatility*p.Volatility/2+p.Volatility*rng.NormFloat64())
+}
+
+// JumpDiffusionProcess is Merton's jump diffusion: GBM plus a Poisson number
+// of jumps per tick with normally distributed log sizes.
+type JumpDiffusionProcess struct {
+	GBMProcess
+	JumpRate float64 // Expected jumps per tick
+	JumpMean float64 // Mean log jump size
+	JumpStd  float64 // Standard deviation of the log jump size
+}
+
+// Next implements PriceProcess.
+func (p JumpDiffusionProcess) Next(rng *rand.Rand, price float64) float64 {
+	price = p.GBMProcess.Next(rng, price)
+	for n := samplePoisson(rng, p.JumpRate); n > 0; n-- {
+		price *= math.Exp(p.JumpMean + p.JumpStd*rng.NormFloat64())
+	}
+	return price
+}
+
+// RegimeSwitchingProcess switches between processes following a Markov chain.
+// Transition[i][j] is the probability of moving from regime i to j on a tick.
+type RegimeSwitchingProcess struct {
+	Regimes    []PriceProcess
+	Transition [][]float64
+	regime     int
+}
+
+// NewRegimeSwitchingProcess creates a regime-switching process starting in regime 0.
+func NewRegimeSwitchingProcess(regimes []PriceProcess, transition [][]float64) (*RegimeSwitchingProcess, error) {
+	if len(transition) != len(regimes) {
+		return nil, fmt.Errorf("transition matrix has %d rows for %d regimes", len(transition), len(regimes))
+	}
+	for i, row := range transition {
+		if len(row) != len(regimes) {
+			return nil, fmt.Errorf("transition row %d has %d entries for %d regimes", i, len(row), len(regimes))
+		}
+	}
+	return &RegimeSwitchingProcess{Regimes: regimes, Transition: transition}, nil
+}
+
+// Regime returns the current regime index.
+func (p *RegimeSwitchingProcess) Regime() int {
+	return p.regime
+}
+
+// Next implements PriceProcess.
+func (p *RegimeSwitchingProcess) Next(rng *rand.Rand, price float64) float64 {
+	u := rng.Float64()
+	for j, prob := range p.Transition[p.regime] {
+		if u < prob {
+			p.regime = j
+			break
+		}
+		u -= prob
+	}
+	return p.Regimes[p.regime].Next(rng, price)
+}
+
+// samplePoisson draws from a Poisson distribution by Knuth's method, which is
+// fine for the small per-tick rates used here.
+func samplePoisson(rng *rand.Rand, rate float64) int {
+	limit := math.Exp(-rate)
+	n := 0
+	for p := rng.Float64(); p > limit; p *= rng.Float64() {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,246 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Quote is a top-of-book (L1) quote.
+type Quote struct {
+	Time    time.Time
+	Bid     float64
+	Ask     float64
+	BidSize float64
+	AskSize float64
+}
+
+// Mid returns the midpoint of the bid and ask.
+func (q Quote) Mid() float64 {
+	return (q.Bid + q.Ask) / 2
+}
+
+// Spread returns the quoted spread in price units.
+func (q Quote) Spread() float64 {
+	return q.Ask - q.Bid
+}
+
+// RelativeSpread returns the spread as a fraction of the mid.
+func (q Quote) RelativeSpread() float64 {
+	return q.Spread() / q.Mid()
+}
+
+// QuoteObserver represents an observer of quotes.
+type QuoteObserver interface {
+	OnQuote(q Quote)
+}
+
+// QuoteObserverFunc adapts a function to the QuoteObserver interface.
+type QuoteObserverFunc func(q Quote)
+
+// OnQuote implements QuoteObserver.
+func (f QuoteObserverFunc) OnQuote(q Quote) {
+	f(q)
+}
+
+// midObserver adapts a price Observer to the QuoteObserver interface.
+type midObserver struct {
+	Observer
+}
+
+// OnQuote implements QuoteObserver.
+func (m midObserver) OnQuote(q Quote) {
+	m.Update(q.Mid())
+}
+
+// QuoteSynthesizer turns a stream of mid prices into quotes around them. The
+// log relative spread follows an AR(1) process around its mean and the
+// displayed size on each side is exponentially distributed. With vol 0 the
+// spread is constant. Observers attached to it are updated synchronously, in
+// order, with each quote.
+type QuoteSynthesizer struct {
+	rng         *rand.Rand
+	meanLog     float64 // Long-run mean of the log relative spread
+	persistence float64 // AR(1) coefficient of the log spread
+	vol         float64 // Innovation standard deviation of the log spread
+	depth       float64 // Mean displayed size per side
+	logSpread   float64
+	clock       func() time.Time
+	observers   []QuoteObserver
+	mux         sync.Mutex
+}
+
+// NewQuoteSynthesizer creates a synthesizer whose spread hovers around
+// spreadBps basis points of the mid.
+func NewQuoteSynthesizer(rng *rand.Rand, spreadBps, persistence, vol, depth float64) *QuoteSynthesizer {
+	meanLog := math.Log(spreadBps / 1e4)
+	return &QuoteSynthesizer{
+		rng:         rng,
+		meanLog:     meanLog,
+		persistence: persistence,
+		vol:         vol,
+		depth:       depth,
+		logSpread:   meanLog,
+		clock:       time.Now,
+	}
+}
+
+// SetClock replaces the clock that timestamps quotes, so replays quote on
+// market time.
+func (s *QuoteSynthesizer) SetClock(clock func() time.Time) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.clock = clock
+}
+
+// Attach registers a quote observer.
+func (s *QuoteSynthesizer) Attach(observer QuoteObserver) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.observers = append(s.observers, observer)
+}
+
+// AttachMid registers a price observer that receives the mid of every quote.
+func (s *QuoteSynthesizer) AttachMid(observer Observer) {
+	s.Attach(midObserver{observer})
+}
+
+// Update implements Observer, quoting around price.
+func (s *QuoteSynthesizer) Update(price float64) {
+	s.mux.Lock()
+	s.logSpread = s.meanLog + s.persistence*(s.logSpread-s.meanLog) + s.vol*s.rng.NormFloat64()
+	half := price * math.Exp(s.logSpread) / 2
+	q := Quote{
+		Time:    s.clock(),
+		Bid:     price - half,
+		Ask:     price + half,
+		BidSize: s.depth * s.rng.ExpFloat64(),
+		AskSize: s.depth * s.rng.ExpFloat64(),
+	}
+	observers := s.observers
+	s.mux.Unlock()
+
+	for _, o := range observers {
+		o.OnQuote(q)
+	}
+}
+
+// SpreadEstimator is a quote observer that feeds the mid to a Model of the
+// mean and estimates the spread dynamics: a discounted Normal-Inverse-Gamma
+// posterior of the log relative spread, its AR(1) persistence and the
+// average displayed depth.
+type SpreadEstimator struct {
+	mid        Model
+	prior      NIGParams // Prior of the log relative spread
+	post       NIGParams
+	forgetting float64
+	last       float64 // Previous log spread
+	count      int
+	sx, sy     float64 // Discounted sums for the AR(1) regression of x_t on x_{t-1}
+	sxx, sxy   float64
+	sw         float64
+	depth      float64 // Discounted sum of the touch sizes
+	dw         float64 // Discounted count of quotes
+	mux        sync.Mutex
+}
+
+// NewSpreadEstimator creates an estimator feeding mids to mid, which may be
+// nil when the mean is modelled elsewhere, for example on returns. The spread
+// posterior starts from prior and keeps forgetting of its weight per quote.
+func NewSpreadEstimator(mid Model, prior NIGParams, forgetting float64) *SpreadEstimator {
+	return &SpreadEstimator{
+		mid:        mid,
+		prior:      prior,
+		post:       prior,
+		forgetting: forgetting,
+	}
+}
+
+// OnQuote implements QuoteObserver.
+func (e *SpreadEstimator) OnQuote(q Quote) {
+	if e.mid != nil {
+		e.mid.Update(q.Mid())
+	}
+
+	rel := q.RelativeSpread()
+	if !(rel > 0) {
+		return // Locked or crossed book
+	}
+	x := math.Log(rel)
+
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.forgetting < 1 {
+		e.post = e.post.discount(e.prior, e.forgetting)
+	}
+	e.post = e.post.update(x)
+
+	lambda := e.forgetting
+	if e.count > 0 {
+		e.sw = lambda*e.sw + 1
+		e.sx = lambda*e.sx + e.last
+		e.sy = lambda*e.sy + x
+		e.sxx = lambda*e.sxx + e.last*e.last
+		e.sxy = lambda*e.sxy + e.last*x
+	}
+	e.depth = lambda*e.depth + (q.BidSize+q.AskSize)/2
+	e.dw = lambda*e.dw + 1
+	e.last = x
+	e.count++
+}
+
+// Mean returns the posterior of the mean of the mid, or false without a mid model.
+func (e *SpreadEstimator) Mean() (MeanPosterior, bool) {
+	if e.mid == nil {
+		return MeanPosterior{}, false
+	}
+	return e.mid.Posterior(), true
+}
+
+// SpreadPosterior returns the posterior of the mean log relative spread.
+func (e *SpreadEstimator) SpreadPosterior() MeanPosterior {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.post.meanPosterior(e.count)
+}
+
+// ExpectedSpread returns the expected relative spread of the next quote,
+// exp(mu + sigma^2/2) at the posterior mean of the log spread parameters.
+func (e *SpreadEstimator) ExpectedSpread() float64 {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	variance := e.post.Beta / (e.post.Alpha - 1)
+	if e.post.Alpha <= 1 {
+		variance = 0
+	}
+	return math.Exp(e.post.Mu + variance/2)
+}
+
+// Persistence returns the AR(1) coefficient of the log spread, or NaN before
+// there is enough data to estimate it.
+func (e *SpreadEstimator) Persistence() float64 {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.sw < 2 {
+		return math.NaN()
+	}
+	den := e.sw*e.sxx - e.sx*e.sx
+	if den <= 0 {
+		return math.NaN() // Constant spread
+	}
+	return (e.sw*e.sxy - e.sx*e.sy) / den
+}
+
+// Depth returns the average displayed size per side.
+func (e *SpreadEstimator) Depth() float64 {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.dw == 0 {
+		return 0
+	}
+	return e.depth / e.dw
+}
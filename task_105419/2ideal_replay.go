@@ -0,0 +1,178 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bar is one OHLCV record of historical market data.
+type Bar struct {
+	Time                   time.Time
+	Open, High, Low, Close float64
+	Volume                 float64
+}
+
+// timestampLayouts are the timestamp formats accepted in OHLCV files, besides Unix seconds.
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseTimestamp parses a timestamp column value.
+func parseTimestamp(s string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", s)
+}
+
+// ReadOHLCV reads bars from CSV with columns timestamp, open, high, low, close,
+// volume. A leading header row is skipped.
+func ReadOHLCV(r io.Reader) ([]Bar, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 6
+	cr.TrimLeadingSpace = true
+
+	var bars []Bar
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return bars, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ts, err := parseTimestamp(strings.TrimSpace(rec[0]))
+		if err != nil {
+			if line == 1 {
+				continue // Header row
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		var vals [5]float64
+		for i := range vals {
+			vals[i], err = strconv.ParseFloat(strings.TrimSpace(rec[i+1]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		bars = append(bars, Bar{
+			Time:   ts,
+			Open:   vals[0],
+			High:   vals[1],
+			Low:    vals[2],
+			Close:  vals[3],
+			Volume: vals[4],
+		})
+	}
+}
+
+// LoadOHLCV reads bars from one or more CSV files and merges them in time order.
+func LoadOHLCV(paths ...string) ([]Bar, error) {
+	var bars []Bar
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		b, err := ReadOHLCV(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		bars = append(bars, b...)
+	}
+	sort.SliceStable(bars, func(i, j int) bool { return bars[i].Time.Before(bars[j].Time) })
+	return bars, nil
+}
+
+// ReplaySource replays historical bars and notifies observers of each close.
+type ReplaySource struct {
+	observerSet
+	bars  []Bar
+	speed float64 // Simulated-time multiplier; 0 replays as fast as possible
+}
+
+// NewReplaySource creates a replay source. With speed 1 bars are spaced by
+// their recorded timestamps, with speed 60 a minute passes in a second, and
+// with speed 0 there is no waiting at all.
+func NewReplaySource(bars []Bar, speed float64) *ReplaySource {
+	return &ReplaySource{
+		bars:  bars,
+		speed: speed,
+	}
+}
+
+// Run replays every bar and returns once the history is exhausted and every
+// observer has caught up, or as soon as ctx is cancelled.
+func (rs *ReplaySource) Run(ctx context.Context) {
+	for i, bar := range rs.bars {
+		if rs.speed > 0 && i > 0 {
+			gap := bar.Time.Sub(rs.bars[i-1].Time)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(float64(gap) / rs.speed)):
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+		rs.notify(ctx, bar.Close)
+	}
+	rs.Drain()
+}
+
+// BarClock is an observer that follows a replay of bars and reports the time
+// of the latest bar it has seen. Components that must run on market time,
+// such as risk limits, read it instead of the wall clock; it should update
+// before them.
+type BarClock struct {
+	bars []Bar
+	seen int
+	mux  sync.Mutex
+}
+
+// NewBarClock creates a clock for a replay of bars.
+func NewBarClock(bars []Bar) *BarClock {
+	return &BarClock{bars: bars}
+}
+
+// Update implements Observer.
+func (c *BarClock) Update(float64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.seen < len(c.bars) {
+		c.seen++
+	}
+}
+
+// Now returns the time of the latest bar seen, or of the first bar before any.
+func (c *BarClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	switch {
+	case c.seen > 0:
+		return c.bars[c.seen-1].Time
+	case len(c.bars) > 0:
+		return c.bars[0].Time
+	}
+	return time.Time{}
+}
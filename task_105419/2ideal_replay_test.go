@@ -0,0 +1,154 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadOHLCV(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	noon := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name  string
+		csv   string
+		times []time.Time // Nil when an error is expected
+	}{
+		{"header", "timestamp,open,high,low,close,volume\n1709251200,1,2,0.5,1.5,100\n", []time.Time{day}},
+		{"no header", "1709251200,1,2,0.5,1.5,100\n", []time.Time{day}},
+		{"empty", "", []time.Time{}},
+		{"header only", "time,o,h,l,c,v\n", []time.Time{}},
+		{"rfc3339", "2024-03-01T12:30:00Z,1,2,0.5,1.5,100\n", []time.Time{noon}},
+		{"rfc3339 offset", "2024-03-01T14:30:00+02:00,1,2,0.5,1.5,100\n", []time.Time{noon}},
+		{"space", "2024-03-01 12:30:00,1,2,0.5,1.5,100\n", []time.Time{noon}},
+		{"no zone", "2024-03-01T12:30:00,1,2,0.5,1.5,100\n", []time.Time{noon}},
+		{"date", "2024-03-01,1,2,0.5,1.5,100\n", []time.Time{day}},
+		{"spaces", " 2024-03-01, 1, 2, 0.5, 1.5, 100\n", []time.Time{day}},
+		{"bad timestamp", "2024-03-01,1,2,0.5,1.5,100\nyesterday,1,2,0.5,1.5,100\n", nil},
+		{"bad price", "2024-03-01,1,2,low,1.5,100\n", nil},
+		{"short row", "2024-03-01,1,2,0.5,1.5\n", nil},
+		{"long row", "2024-03-01,1,2,0.5,1.5,100,7\n", nil},
+		{"two headers", "a,b,c,d,e,f\na,b,c,d,e,f\n", nil},
+	} {
+		bars, err := ReadOHLCV(strings.NewReader(tc.csv))
+		if tc.times == nil {
+			if err == nil {
+				t.Errorf("%s: read %+v, want an error", tc.name, bars)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if len(bars) != len(tc.times) {
+			t.Errorf("%s: %d bars, want %d", tc.name, len(bars), len(tc.times))
+			continue
+		}
+		for i, b := range bars {
+			if !b.Time.Equal(tc.times[i]) {
+				t.Errorf("%s: bar %d at %v, want %v", tc.name, i, b.Time, tc.times[i])
+			}
+			if b != (Bar{Time: b.Time, Open: 1, High: 2, Low: 0.5, Close: 1.5, Volume: 100}) {
+				t.Errorf("%s: bar %d = %+v", tc.name, i, b)
+			}
+		}
+	}
+}
+
+func TestLoadOHLCVMergesInTimeOrder(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.csv")
+	b := filepath.Join(dir, "b.csv")
+	for path, csv := range map[string]string{
+		a: "t,o,h,l,c,v\n2024-03-01,1,1,1,1,0\n2024-03-03,3,3,3,3,0\n",
+		b: "2024-03-02,2,2,2,2,0\n2024-03-04,4,4,4,4,0\n",
+	} {
+		if err := os.WriteFile(path, []byte(csv), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bars, err := LoadOHLCV(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var closes []float64
+	for _, bar := range bars {
+		closes = append(closes, bar.Close)
+	}
+	if want := []float64{1, 2, 3, 4}; !slices.Equal(closes, want) {
+		t.Errorf("closes %v, want %v", closes, want)
+	}
+	if _, err := LoadOHLCV(a, filepath.Join(dir, "missing.csv")); err == nil {
+		t.Error("loading a missing file succeeded")
+	}
+}
+
+// minuteBars returns n bars one minute apart closing at 0, 1, 2, ...
+func minuteBars(n int) []Bar {
+	start := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	bars := make([]Bar, n)
+	for i := range bars {
+		bars[i] = Bar{Time: start.Add(time.Duration(i) * time.Minute), Close: float64(i)}
+	}
+	return bars
+}
+
+func TestReplaySourcePacing(t *testing.T) {
+	bars := minuteBars(5)
+	for _, tc := range []struct {
+		speed    float64
+		min, max time.Duration
+	}{
+		{0, 0, 100 * time.Millisecond},
+		{1200, 200 * time.Millisecond, 2 * time.Second}, // 4 gaps of 50ms
+	} {
+		rs := NewReplaySource(bars, tc.speed)
+		r := &recorder{}
+		rs.AttachBlocking(r)
+		start := time.Now()
+		rs.Run(context.Background())
+		elapsed := time.Since(start)
+		if elapsed < tc.min || elapsed > tc.max {
+			t.Errorf("speed %v took %v, want between %v and %v", tc.speed, elapsed, tc.min, tc.max)
+		}
+		if got := r.seen(); !slices.Equal(got, []float64{0, 1, 2, 3, 4}) {
+			t.Errorf("speed %v delivered %v, want every close in order", tc.speed, got)
+		}
+	}
+}
+
+func TestReplaySourceCancel(t *testing.T) {
+	rs := NewReplaySource(minuteBars(5), 1) // A minute between bars
+	r := &recorder{}
+	rs.AttachBlocking(r)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	within(t, "Run after cancel", func() { rs.Run(ctx) })
+	rs.Drain()
+	if got := r.seen(); !slices.Equal(got, []float64{0}) {
+		t.Errorf("delivered %v before cancel, want only the first close", got)
+	}
+}
+
+func TestBarClock(t *testing.T) {
+	bars := minuteBars(2)
+	c := NewBarClock(bars)
+	for i, want := range []time.Time{bars[0].Time, bars[0].Time, bars[1].Time, bars[1].Time} {
+		if got := c.Now(); !got.Equal(want) {
+			t.Errorf("after %d updates Now() = %v, want %v", i, got, want)
+		}
+		c.Update(0)
+	}
+	if !NewBarClock(nil).Now().IsZero() {
+		t.Error("a clock without bars is not at the zero time")
+	}
+}
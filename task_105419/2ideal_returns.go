@@ -0,0 +1,112 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// ReturnKind selects how consecutive prices are turned into returns.
+type ReturnKind int
+
+const (
+	SimpleReturns ReturnKind = iota // p_t/p_{t-1} - 1
+	LogReturns                      // log(p_t/p_{t-1})
+)
+
+// ParseReturnKind parses "simple" or "log".
+func ParseReturnKind(s string) (ReturnKind, error) {
+	switch s {
+	case "simple":
+		return SimpleReturns, nil
+	case "log":
+		return LogReturns, nil
+	}
+	return 0, fmt.Errorf("unknown return kind %q", s)
+}
+
+// volatilityWarmup is the number of returns used to seed the volatility
+// estimate before normalized returns are forwarded.
+const volatilityWarmup = 20
+
+// ReturnsTransform sits between a data source and its observers, converting
+// prices into returns. Observers attached to it are updated synchronously, in
+// order, with each return.
+type ReturnsTransform struct {
+	kind      ReturnKind
+	normalize bool    // Divide returns by an EWMA volatility estimate
+	decay     float64 // EWMA decay of the variance estimate
+	last      float64
+	variance  float64
+	seen      int // Returns observed so far
+	observers []Observer
+	mux       sync.Mutex
+}
+
+// NewReturnsTransform creates a transform forwarding raw returns.
+func NewReturnsTransform(kind ReturnKind) *ReturnsTransform {
+	return &ReturnsTransform{kind: kind}
+}
+
+// NewNormalizedReturnsTransform creates a transform forwarding returns divided
+// by the EWMA volatility estimated from the returns before them, so the
+// estimators see roughly unit-variance input whatever the market regime.
+func NewNormalizedReturnsTransform(kind ReturnKind, decay float64) *ReturnsTransform {
+	return &ReturnsTransform{kind: kind, normalize: true, decay: decay}
+}
+
+// Attach adds an observer of the transformed series.
+func (t *ReturnsTransform) Attach(observer Observer) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.observers = append(t.observers, observer)
+}
+
+// Update implements Observer.
+func (t *ReturnsTransform) Update(price float64) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	last := t.last
+	t.last = price
+	if last <= 0 {
+		return // First price, or no meaningful return from a non-positive one
+	}
+	r := price/last - 1
+	if t.kind == LogReturns {
+		r = math.Log(price / last)
+	}
+	t.seen++
+
+	if t.normalize {
+		prev := t.variance
+		if t.seen <= volatilityWarmup {
+			// Seed with the plain average of squared returns
+			t.variance += (r*r - t.variance) / float64(t.seen)
+			return
+		}
+		t.variance = t.decay*t.variance + (1-t.decay)*r*r
+		if prev <= 0 {
+			return
+		}
+		r /= math.Sqrt(prev)
+	}
+
+	for _, observer := range t.observers {
+		observer.Update(r)
+	}
+}
+
+// Returns converts a price series into raw returns of kind.
+func Returns(prices []float64, kind ReturnKind) []float64 {
+	rt := NewReturnsTransform(kind)
+	c := &collector{}
+	rt.Attach(c)
+	for _, price := range prices {
+		rt.Update(price)
+	}
+	return c.values
+}
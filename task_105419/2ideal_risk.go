@@ -0,0 +1,330 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxRejections is the number of rejections kept for Rejections.
+const maxRejections = 1000
+
+// RiskLimits are the pre-trade limits enforced by a RiskManager. Zero means unlimited.
+type RiskLimits struct {
+	MaxPosition        float64 // Absolute units held per symbol
+	MaxGrossExposure   float64 // Sum of absolute position values across symbols
+	MaxDailyLoss       float64 // Loss since the start of the day that stops new risk
+	MaxOrdersPerMinute int
+}
+
+// Order is a request to trade Quantity units of Symbol, positive to buy.
+type Order struct {
+	Time     time.Time `json:"time"`
+	Symbol   string    `json:"symbol"`
+	Quantity float64   `json:"quantity"`
+	Price    float64   `json:"price"` // Expected fill price
+}
+
+// Rejection records an order refused by a RiskManager.
+type Rejection struct {
+	Order  Order  `json:"order"`
+	Reason string `json:"reason"`
+}
+
+// ErrKilled is returned for every order while the kill switch is engaged.
+var ErrKilled = errors.New("kill switch engaged")
+
+// RiskStatus is a snapshot of a RiskManager.
+type RiskStatus struct {
+	Killed           bool
+	KillReason       string
+	Positions        map[string]float64
+	GrossExposure    float64
+	PnL              float64 // Since the manager was created
+	DailyPnL         float64
+	OrdersLastMinute int
+	Rejected         map[string]int // Rejections per limit
+}
+
+// RiskManager sits between signals and execution. Every order is checked
+// against the limits before it is sent; fills and prices are reported back
+// so positions, exposure and P&L stay current. Orders that only reduce risk
+// pass the position, exposure and loss limits, but nothing passes the kill
+// switch.
+type RiskManager struct {
+	limits     RiskLimits
+	clock      func() time.Time
+	positions  map[string]float64
+	prices     map[string]float64
+	cash       float64
+	day        time.Time // Start of the current trading day
+	dayStart   float64   // P&L at the start of the day
+	orders     []time.Time
+	killed     bool
+	killReason string
+	rejections []Rejection
+	rejected   map[string]int
+	listeners  []func(Rejection)
+	mux        sync.Mutex
+}
+
+// NewRiskManager creates a manager enforcing limits, using the wall clock.
+func NewRiskManager(limits RiskLimits) *RiskManager {
+	return &RiskManager{
+		limits:    limits,
+		clock:     time.Now,
+		positions: make(map[string]float64),
+		prices:    make(map[string]float64),
+		rejected:  make(map[string]int),
+	}
+}
+
+// SetClock replaces the clock used for the order rate and the daily loss
+// reset, so runs can be reproduced and replays run on market time.
+func (r *RiskManager) SetClock(clock func() time.Time) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.clock = clock
+}
+
+// OnReject registers a function called with every rejection.
+func (r *RiskManager) OnReject(fn func(Rejection)) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+// Kill engages the kill switch: every order is rejected until Resume.
+func (r *RiskManager) Kill(reason string) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.killed = true
+	r.killReason = reason
+}
+
+// Resume releases the kill switch.
+func (r *RiskManager) Resume() {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.killed = false
+	r.killReason = ""
+}
+
+// Check validates order against the limits at order.Time, or at the clock's
+// time when that is zero. An accepted order counts towards the order rate; a
+// rejected one is recorded and returned as an error.
+func (r *RiskManager) Check(order Order) error {
+	r.mux.Lock()
+	if order.Time.IsZero() {
+		order.Time = r.clock()
+	}
+	now := order.Time
+	limit, err := r.check(order, now)
+	if err == nil {
+		r.orders = append(r.orders, now)
+		r.mux.Unlock()
+		return nil
+	}
+
+	rej := Rejection{Order: order, Reason: err.Error()}
+	r.rejected[limit]++
+	r.rejections = append(r.rejections, rej)
+	if len(r.rejections) > maxRejections {
+		r.rejections = slices.Delete(r.rejections, 0, len(r.rejections)-maxRejections)
+	}
+	listeners := slices.Clone(r.listeners)
+	r.mux.Unlock()
+
+	for _, fn := range listeners {
+		fn(rej)
+	}
+	return err
+}
+
+// check returns the name of the limit order breaches, with the reason.
+func (r *RiskManager) check(order Order, now time.Time) (string, error) {
+	if r.killed {
+		return "kill", fmt.Errorf("%w: %s", ErrKilled, r.killReason)
+	}
+
+	cutoff := now.Add(-time.Minute)
+	i := 0
+	for i < len(r.orders) && !r.orders[i].After(cutoff) {
+		i++
+	}
+	r.orders = r.orders[i:]
+	if limit := r.limits.MaxOrdersPerMinute; limit > 0 && len(r.orders) >= limit {
+		return "rate", fmt.Errorf("%d orders in the last minute, limit %d", len(r.orders), limit)
+	}
+
+	price := order.Price
+	if price == 0 {
+		price = r.prices[order.Symbol]
+	}
+	pos := r.positions[order.Symbol]
+	next := pos + order.Quantity
+	increases := math.Abs(next) > math.Abs(pos)
+	if !increases {
+		return "", nil // Reducing risk is always allowed
+	}
+
+	if limit := r.limits.MaxPosition; limit > 0 && math.Abs(next) > limit {
+		return "position", fmt.Errorf("%s position %g would exceed %g", order.Symbol, next, limit)
+	}
+	if limit := r.limits.MaxGrossExposure; limit > 0 {
+		gross := r.gross() + (math.Abs(next)-math.Abs(pos))*price
+		if gross > limit {
+			return "gross", fmt.Errorf("gross exposure %.2f would exceed %.2f", gross, limit)
+		}
+	}
+	if limit := r.limits.MaxDailyLoss; limit > 0 {
+		if loss := -r.dailyPnL(now); loss > limit {
+			return "loss", fmt.Errorf("daily loss %.2f exceeds %.2f", loss, limit)
+		}
+	}
+	return "", nil
+}
+
+// Fill records an executed trade of qty units at price, paying cost.
+func (r *RiskManager) Fill(symbol string, qty, price, cost float64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.rollDay(r.clock())
+	r.positions[symbol] += qty
+	r.prices[symbol] = price
+	r.cash -= qty*price + cost
+}
+
+// Mark updates the price used to value symbol.
+func (r *RiskManager) Mark(symbol string, price float64) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.rollDay(r.clock())
+	r.prices[symbol] = price
+}
+
+// pnl returns the mark-to-market P&L since the manager was created.
+func (r *RiskManager) pnl() float64 {
+	pnl := r.cash
+	for s, pos := range r.positions {
+		pnl += pos * r.prices[s]
+	}
+	return pnl
+}
+
+// rollDay starts a new trading day when now is past the current one. It runs
+// before every change to positions or prices, so the day starts from the P&L
+// before the first of them.
+func (r *RiskManager) rollDay(now time.Time) {
+	y, m, d := now.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	if !day.Equal(r.day) {
+		r.day = day
+		r.dayStart = r.pnl()
+	}
+}
+
+// dailyPnL returns the P&L since the start of the day of now.
+func (r *RiskManager) dailyPnL(now time.Time) float64 {
+	r.rollDay(now)
+	return r.pnl() - r.dayStart
+}
+
+// gross returns the sum of absolute position values.
+func (r *RiskManager) gross() float64 {
+	var gross float64
+	for s, pos := range r.positions {
+		gross += math.Abs(pos * r.prices[s])
+	}
+	return gross
+}
+
+// Status returns the current positions, exposure, P&L and limit usage.
+func (r *RiskManager) Status() RiskStatus {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	now := r.clock()
+	cutoff := now.Add(-time.Minute)
+	recent := 0
+	for _, t := range r.orders {
+		if t.After(cutoff) {
+			recent++
+		}
+	}
+	positions := make(map[string]float64, len(r.positions))
+	for s, pos := range r.positions {
+		positions[s] = pos
+	}
+	rejected := make(map[string]int, len(r.rejected))
+	for k, n := range r.rejected {
+		rejected[k] = n
+	}
+	return RiskStatus{
+		Killed:           r.killed,
+		KillReason:       r.killReason,
+		Positions:        positions,
+		GrossExposure:    r.gross(),
+		PnL:              r.pnl(),
+		DailyPnL:         r.dailyPnL(now),
+		OrdersLastMinute: recent,
+		Rejected:         rejected,
+	}
+}
+
+// Rejections returns the most recent rejections, oldest first.
+func (r *RiskManager) Rejections() []Rejection {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return slices.Clone(r.rejections)
+}
+
+// WatchKillFile engages the kill switch while path exists and releases it
+// when the file is removed, checking every interval until ctx is done. It
+// gives operators a manual switch that works without a control plane.
+func (r *RiskManager) WatchKillFile(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	engaged := false
+	for {
+		_, err := os.Stat(path)
+		switch exists := err == nil; {
+		case exists && !engaged:
+			r.Kill("kill file " + path)
+			engaged = true
+		case !exists && engaged:
+			r.Resume()
+			engaged = false
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// String summarizes the status on one line.
+func (s RiskStatus) String() string {
+	str := fmt.Sprintf("pnl=%.2f daily=%.2f gross=%.2f orders/min=%d", s.PnL, s.DailyPnL, s.GrossExposure, s.OrdersLastMinute)
+	if s.Killed {
+		str += " KILLED (" + s.KillReason + ")"
+	}
+	limits := make([]string, 0, len(s.Rejected))
+	for k := range s.Rejected {
+		limits = append(limits, k)
+	}
+	sort.Strings(limits)
+	for _, k := range limits {
+		str += fmt.Sprintf(" rejected[%s]=%d", k, s.Rejected[k])
+	}
+	return str
+}
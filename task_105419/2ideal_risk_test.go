@@ -0,0 +1,50 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRiskUsesOrderTime(t *testing.T) {
+	r := NewRiskManager(RiskLimits{MaxOrdersPerMinute: 1})
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	// Daily bars replayed within one wall-clock minute
+	for i := 0; i < 5; i++ {
+		order := Order{Time: day.AddDate(0, 0, i), Symbol: "X", Quantity: 1, Price: 100}
+		if err := r.Check(order); err != nil {
+			t.Fatalf("bar %d: %v", i, err)
+		}
+	}
+	if err := r.Check(Order{Time: day.AddDate(0, 0, 4).Add(time.Second), Symbol: "X", Quantity: 1}); err == nil {
+		t.Error("second order within a minute of bar time was accepted")
+	}
+}
+
+func TestRiskDailyLossResetsOnClock(t *testing.T) {
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	r := NewRiskManager(RiskLimits{MaxDailyLoss: 50})
+	r.SetClock(func() time.Time { return now })
+	r.Fill("X", 10, 100, 0)
+	r.Mark("X", 90) // Lost 100 today
+
+	if err := r.Check(Order{Symbol: "X", Quantity: 1}); err == nil {
+		t.Error("order accepted past the daily loss limit")
+	}
+	if err := r.Check(Order{Symbol: "X", Quantity: -1}); err != nil {
+		t.Errorf("reducing order rejected: %v", err)
+	}
+
+	now = now.AddDate(0, 0, 1)
+	if err := r.Check(Order{Symbol: "X", Quantity: 1}); err != nil {
+		t.Errorf("order rejected on the next day: %v", err)
+	}
+
+	r.Kill("test")
+	if err := r.Check(Order{Symbol: "X", Quantity: -1}); !errors.Is(err, ErrKilled) {
+		t.Errorf("killed Check = %v, want ErrKilled", err)
+	}
+}
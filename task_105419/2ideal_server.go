@@ -0,0 +1,590 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultHistorySize is the number of decisions kept for /signals.
+const defaultHistorySize = 1000
+
+// ObserverFactory builds a named observer for one symbol's price stream.
+type ObserverFactory func(symbol string) Observer
+
+// attachment identifies an observer attached through the control plane.
+type attachment struct {
+	symbol, name string
+}
+
+// ControlPlane operates a long-running trading process over HTTP/JSON. It
+// turns every tick of a MultiSource into a Decision with the current
+// threshold and confidence, keeps a bounded history of them, and lets
+// operators attach registered observers to the per-symbol sources.
+type ControlPlane struct {
+	market      *MultiSource
+	sources     map[string]*DataSource
+	factories   map[string]ObserverFactory
+	attached    map[attachment]Observer
+	threshold   float64
+	confidence  float64
+	history     []Decision // Ring buffer of the latest decisions
+	next        int        // Next write position in history
+	historySize int
+	ticks       map[string]uint64
+	signals     map[string]map[int]uint64 // Decisions per symbol and signal
+	listeners   []func(Decision)
+	correct     func(Decision) Decision
+	mux         sync.Mutex
+}
+
+// NewControlPlane creates a control plane for market and subscribes it to every tick.
+func NewControlPlane(market *MultiSource, threshold, confidence float64) *ControlPlane {
+	c := &ControlPlane{
+		market:      market,
+		sources:     make(map[string]*DataSource),
+		factories:   make(map[string]ObserverFactory),
+		attached:    make(map[attachment]Observer),
+		threshold:   threshold,
+		confidence:  confidence,
+		historySize: defaultHistorySize,
+		ticks:       make(map[string]uint64),
+		signals:     make(map[string]map[int]uint64),
+	}
+	market.Subscribe(TickObserverFunc(c.onTick))
+	return c
+}
+
+// AddSource registers the price source of symbol, so observers can be attached to it.
+func (c *ControlPlane) AddSource(symbol string, source *DataSource) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.sources[symbol] = source
+}
+
+// RegisterObserver makes an observer type attachable by name at runtime.
+func (c *ControlPlane) RegisterObserver(name string, factory ObserverFactory) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.factories[name] = factory
+}
+
+// OnDecision registers a function called with every decision.
+func (c *ControlPlane) OnDecision(fn func(Decision)) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// SetCorrection installs fn to adjust every decision before it is recorded,
+// counted and passed to the OnDecision functions, for example a
+// portfolio-level multiple-testing correction.
+func (c *ControlPlane) SetCorrection(fn func(Decision) Decision) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.correct = fn
+}
+
+// Params returns the current threshold and confidence.
+func (c *ControlPlane) Params() (threshold, confidence float64) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.threshold, c.confidence
+}
+
+// SetParams changes the threshold and confidence used for new decisions.
+func (c *ControlPlane) SetParams(threshold, confidence float64) error {
+	if !(confidence > 0.5 && confidence < 1) {
+		return fmt.Errorf("confidence %v must be in (0.5, 1)", confidence)
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.threshold, c.confidence = threshold, confidence
+	return nil
+}
+
+// onTick decides on the symbol whose estimator has just been updated.
+func (c *ControlPlane) onTick(tick Tick) {
+	c.mux.Lock()
+	threshold, confidence, correct := c.threshold, c.confidence, c.correct
+	c.mux.Unlock()
+	d := c.market.Estimator(tick.Symbol).GenerateSignal(threshold, confidence)
+	d.Symbol = tick.Symbol
+	d.Time = tick.Time
+	if correct != nil {
+		d = correct(d)
+	}
+
+	c.mux.Lock()
+	if len(c.history) < c.historySize {
+		c.history = append(c.history, d)
+	} else {
+		c.history[c.next] = d
+	}
+	c.next = (c.next + 1) % c.historySize
+	c.ticks[tick.Symbol]++
+	if c.signals[tick.Symbol] == nil {
+		c.signals[tick.Symbol] = make(map[int]uint64)
+	}
+	c.signals[tick.Symbol][d.Signal]++
+	listeners := slices.Clone(c.listeners)
+	c.mux.Unlock()
+
+	for _, fn := range listeners {
+		fn(d)
+	}
+}
+
+// History returns up to limit of the most recent decisions, oldest first.
+// A symbol other than "" keeps only that symbol's decisions.
+func (c *ControlPlane) History(symbol string, limit int) []Decision {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	// Oldest first: once the ring is full it starts at next
+	ordered := c.history
+	if len(c.history) == c.historySize {
+		ordered = append(slices.Clone(c.history[c.next:]), c.history[:c.next]...)
+	}
+	out := make([]Decision, 0, min(limit, len(ordered)))
+	for i := len(ordered) - 1; i >= 0 && len(out) < limit; i-- {
+		if symbol == "" || ordered[i].Symbol == symbol {
+			out = append(out, ordered[i])
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// Attach creates the named observer for symbol and attaches it to the symbol's source.
+func (c *ControlPlane) Attach(symbol, name string) error {
+	source, factory, err := c.reserve(symbol, name)
+	if err != nil {
+		return err
+	}
+	// Operators attach observers to a live market, so a slow one coalesces
+	// rather than stalling the source and every decision behind it
+	observer := factory(symbol)
+	source.AttachBuffered(observer, DeliverCoalesce, defaultObserverBuffer)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.attached[attachment{symbol, name}] = observer
+	return nil
+}
+
+// reserve validates an attachment and records it as pending, so concurrent
+// Attach calls cannot attach the same observer twice.
+func (c *ControlPlane) reserve(symbol, name string) (*DataSource, ObserverFactory, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	source, ok := c.sources[symbol]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown symbol %q", symbol)
+	}
+	factory, ok := c.factories[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown observer %q", name)
+	}
+	key := attachment{symbol, name}
+	if _, ok := c.attached[key]; ok {
+		return nil, nil, fmt.Errorf("observer %q is already attached to %s", name, symbol)
+	}
+	c.attached[key] = nil // Pending until the source has it
+	return source, factory, nil
+}
+
+// Detach removes an observer attached with Attach.
+func (c *ControlPlane) Detach(symbol, name string) error {
+	key := attachment{symbol, name}
+	c.mux.Lock()
+	observer := c.attached[key]
+	if observer != nil {
+		delete(c.attached, key)
+	}
+	source := c.sources[symbol]
+	c.mux.Unlock()
+
+	if observer == nil {
+		return fmt.Errorf("observer %q is not attached to %s", name, symbol)
+	}
+	source.Detach(observer) // Without c.mux, so the source never waits on it
+	return nil
+}
+
+// Handler returns the HTTP API:
+//
+//	GET  /posterior[?symbol=S]       posterior per symbol
+//	GET  /signals[?symbol=S&limit=N] recent decisions
+//	GET  /params, PUT /params        threshold and confidence
+//	GET  /observers                  registered and attached observers with lag counters
+//	POST /observers/attach           {"symbol": S, "name": N}
+//	POST /observers/detach           {"symbol": S, "name": N}
+//	GET  /metrics                    Prometheus text format
+func (c *ControlPlane) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/posterior", c.handlePosterior)
+	mux.HandleFunc("/signals", c.handleSignals)
+	mux.HandleFunc("/params", c.handleParams)
+	mux.HandleFunc("/observers", c.handleObservers)
+	mux.HandleFunc("/observers/attach", c.handleAttach)
+	mux.HandleFunc("/observers/detach", c.handleAttach)
+	mux.HandleFunc("/metrics", c.handleMetrics)
+	return mux
+}
+
+// posteriorView is the JSON form of one symbol's posterior.
+type posteriorView struct {
+	Symbol string    `json:"symbol"`
+	Params NIGParams `json:"params"`
+	Mean   float64   `json:"mean"`
+	Scale  float64   `json:"scale"`
+	DF     float64   `json:"df"`
+	Count  int       `json:"observations"`
+}
+
+func (c *ControlPlane) handlePosterior(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	symbols := c.market.Symbols()
+	if s := r.URL.Query().Get("symbol"); s != "" {
+		if !slices.Contains(symbols, s) {
+			httpError(w, http.StatusNotFound, fmt.Errorf("unknown symbol %q", s))
+			return
+		}
+		symbols = []string{s}
+	}
+	views := make([]posteriorView, 0, len(symbols))
+	for _, s := range symbols {
+		e := c.market.Estimator(s)
+		post := e.Posterior()
+		views = append(views, posteriorView{
+			Symbol: s,
+			Params: e.Params(),
+			Mean:   post.Mean,
+			Scale:  post.Scale,
+			DF:     post.DF,
+			Count:  post.Count,
+		})
+	}
+	writeJSON(w, views)
+}
+
+func (c *ControlPlane) handleSignals(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			httpError(w, http.StatusBadRequest, fmt.Errorf("bad limit %q", s))
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, c.History(r.URL.Query().Get("symbol"), limit))
+}
+
+// paramsView is the JSON form of the decision parameters.
+type paramsView struct {
+	Threshold  *float64 `json:"threshold"`
+	Confidence *float64 `json:"confidence"`
+}
+
+func (c *ControlPlane) handleParams(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		var req paramsView
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+		threshold, confidence := c.Params()
+		if req.Threshold != nil {
+			threshold = *req.Threshold
+		}
+		if req.Confidence != nil {
+			confidence = *req.Confidence
+		}
+		if err := c.SetParams(threshold, confidence); err != nil {
+			httpError(w, http.StatusBadRequest, err)
+			return
+		}
+	default:
+		allowMethod(w, r, http.MethodGet, http.MethodPut)
+		return
+	}
+	threshold, confidence := c.Params()
+	writeJSON(w, paramsView{Threshold: &threshold, Confidence: &confidence})
+}
+
+// observerView is the JSON form of an attached observer.
+type observerView struct {
+	Symbol    string `json:"symbol"`
+	Name      string `json:"name"`
+	Pending   int    `json:"pending"`
+	Delivered uint64 `json:"delivered"`
+	Dropped   uint64 `json:"dropped"`
+	Coalesced uint64 `json:"coalesced"`
+}
+
+// observerViews lists attached observers with their lag counters.
+func (c *ControlPlane) observerViews() []observerView {
+	c.mux.Lock()
+	attached := make(map[attachment]Observer, len(c.attached))
+	sources := make(map[attachment]*DataSource, len(c.attached))
+	for key, observer := range c.attached {
+		if observer != nil {
+			attached[key] = observer
+			sources[key] = c.sources[key.symbol]
+		}
+	}
+	c.mux.Unlock()
+
+	// Stats locks the sources, so c.mux is not held. Empty encodes as [], not null
+	views := []observerView{}
+	for key, observer := range attached {
+		for _, st := range sources[key].Stats() {
+			if st.Observer == observer {
+				views = append(views, observerView{
+					Symbol:    key.symbol,
+					Name:      key.name,
+					Pending:   st.Pending,
+					Delivered: st.Delivered,
+					Dropped:   st.Dropped,
+					Coalesced: st.Coalesced,
+				})
+			}
+		}
+	}
+	sort.Slice(views, func(i, j int) bool {
+		if views[i].Symbol != views[j].Symbol {
+			return views[i].Symbol < views[j].Symbol
+		}
+		return views[i].Name < views[j].Name
+	})
+	return views
+}
+
+func (c *ControlPlane) handleObservers(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	c.mux.Lock()
+	names := []string{}
+	for name := range c.factories {
+		names = append(names, name)
+	}
+	c.mux.Unlock()
+	sort.Strings(names)
+	writeJSON(w, map[string]any{
+		"available": names,
+		"attached":  c.observerViews(),
+	})
+}
+
+func (c *ControlPlane) handleAttach(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	var req struct {
+		Symbol string `json:"symbol"`
+		Name   string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, err)
+		return
+	}
+	var err error
+	if r.URL.Path == "/observers/detach" {
+		err = c.Detach(req.Symbol, req.Name)
+	} else {
+		err = c.Attach(req.Symbol, req.Name)
+	}
+	if err != nil {
+		httpError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, c.observerViews())
+}
+
+func (c *ControlPlane) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	threshold, confidence := c.Params()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP estimator_threshold Threshold used for decisions.")
+	fmt.Fprintln(w, "# TYPE estimator_threshold gauge")
+	fmt.Fprintf(w, "estimator_threshold %g\n", threshold)
+	fmt.Fprintln(w, "# HELP estimator_confidence Confidence used for decisions.")
+	fmt.Fprintln(w, "# TYPE estimator_confidence gauge")
+	fmt.Fprintf(w, "estimator_confidence %g\n", confidence)
+
+	symbols := c.market.Symbols()
+	c.mux.Lock()
+	fmt.Fprintln(w, "# HELP estimator_ticks_total Ticks processed per symbol.")
+	fmt.Fprintln(w, "# TYPE estimator_ticks_total counter")
+	for _, s := range symbols {
+		fmt.Fprintf(w, "estimator_ticks_total{symbol=%q} %d\n", s, c.ticks[s])
+	}
+	fmt.Fprintln(w, "# HELP estimator_decisions_total Decisions per symbol and signal.")
+	fmt.Fprintln(w, "# TYPE estimator_decisions_total counter")
+	for _, s := range symbols {
+		for _, sig := range []int{-1, 0, 1} {
+			fmt.Fprintf(w, "estimator_decisions_total{symbol=%q,signal=\"%d\"} %d\n", s, sig, c.signals[s][sig])
+		}
+	}
+	c.mux.Unlock()
+
+	fmt.Fprintln(w, "# HELP estimator_posterior_mean Posterior mean per symbol.")
+	fmt.Fprintln(w, "# TYPE estimator_posterior_mean gauge")
+	for _, s := range symbols {
+		fmt.Fprintf(w, "estimator_posterior_mean{symbol=%q} %g\n", s, c.market.Estimator(s).Posterior().Mean)
+	}
+	fmt.Fprintln(w, "# HELP estimator_probability_above Posterior probability that the mean exceeds the threshold.")
+	fmt.Fprintln(w, "# TYPE estimator_probability_above gauge")
+	for _, s := range symbols {
+		fmt.Fprintf(w, "estimator_probability_above{symbol=%q} %g\n", s, c.market.Estimator(s).ProbabilityAbove(threshold))
+	}
+
+	views := c.observerViews()
+	fmt.Fprintln(w, "# HELP observer_pending Prices buffered for an observer.")
+	fmt.Fprintln(w, "# TYPE observer_pending gauge")
+	for _, v := range views {
+		fmt.Fprintf(w, "observer_pending{symbol=%q,observer=%q} %d\n", v.Symbol, v.Name, v.Pending)
+	}
+	fmt.Fprintln(w, "# HELP observer_dropped_total Prices dropped or coalesced for an observer.")
+	fmt.Fprintln(w, "# TYPE observer_dropped_total counter")
+	for _, v := range views {
+		fmt.Fprintf(w, "observer_dropped_total{symbol=%q,observer=%q} %d\n", v.Symbol, v.Name, v.Dropped+v.Coalesced)
+	}
+}
+
+// allowMethod replies 405 unless r uses one of methods.
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	if slices.Contains(methods, r.Method) {
+		return true
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	httpError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	return false
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		httpError(w, http.StatusInternalServerError, err)
+	}
+}
+
+// httpError writes err as a JSON error body.
+func httpError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+// priceLogger is an observer that logs every price of a symbol.
+type priceLogger struct {
+	symbol string
+}
+
+// Update implements Observer.
+func (l *priceLogger) Update(price float64) {
+	log.Printf("%s %.4f", l.symbol, price)
+}
+
+// runControlPlane runs synthetic GBM markets for symbols, with a discounted
+// returns estimator per symbol, behind the HTTP control plane and the
+// decision stream on addr until interrupted. Every decision is corrected by
+// portfolio before it is recorded, streamed or served on /signals, and the
+// whole corrected portfolio is served on /portfolio.
+func runControlPlane(addr string, symbols []string, tick time.Duration, seed int64, portfolio *PortfolioDecider) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	returnsPrior := NIGParams{Mu: 0, Kappa: 1, Alpha: 2, Beta: 1e-4}
+	market := NewMultiSource(func(string) *BayesianEstimator {
+		return NewDiscountedEstimator(returnsPrior, 0.99)
+	})
+	control := NewControlPlane(market, 0, 0.95)
+	control.RegisterObserver("logger", func(symbol string) Observer {
+		return &priceLogger{symbol: symbol}
+	})
+	control.RegisterObserver("changepoint", func(symbol string) Observer {
+		detector := NewChangePointDetector(returnsPrior, 200, 10, 0.5)
+		detector.Subscribe(func(s ChangePointState) {
+			if s.Changed {
+				log.Printf("%s: change point at tick %d (P=%.2f)", symbol, s.Tick, s.Probability)
+			}
+		})
+		rt := NewReturnsTransform(LogReturns)
+		rt.Attach(detector)
+		return rt
+	})
+
+	for i, symbol := range symbols {
+		s := seed
+		if s != 0 {
+			s += int64(i)
+		}
+		ds, err := newSyntheticMarket("gbm", tick, s)
+		if err != nil {
+			return err
+		}
+		rt := NewReturnsTransform(LogReturns)
+		rt.Attach(market.Feed(symbol))
+		ds.AttachBlocking(rt)
+		control.AddSource(symbol, ds)
+		go ds.Start(ctx)
+	}
+
+	hub := NewSignalHub(64)
+	control.SetCorrection(portfolio.Correct)
+	control.OnDecision(hub.Publish)
+	mux := http.NewServeMux()
+	mux.Handle("/", control.Handler())
+	mux.Handle("/stream", hub.Handler())
+	mux.Handle("/stream/", hub.Handler())
+	mux.HandleFunc("/portfolio", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodGet) {
+			writeJSON(w, portfolio.Decide())
+		}
+	})
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	srv.RegisterOnShutdown(hub.Close)
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	log.Printf("Control plane listening on %s for %v", addr, symbols)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
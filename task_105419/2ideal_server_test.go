@@ -0,0 +1,163 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestControlPlane returns a control plane for symbol X, its price source
+// and a server for its handler. The recorder observer is attachable as "rec".
+func newTestControlPlane(t *testing.T, rec *recorder) (*ControlPlane, *DataSource, *httptest.Server) {
+	t.Helper()
+	market := NewMultiSource(func(string) *BayesianEstimator {
+		return NewBayesianEstimator(NIGParams{Mu: 0, Kappa: 1, Alpha: 2, Beta: 1})
+	})
+	c := NewControlPlane(market, 0, 0.95)
+	ds := NewDataSource(time.Second)
+	c.AddSource("X", ds)
+	c.RegisterObserver("rec", func(string) Observer { return rec })
+	market.Publish(Tick{Symbol: "X", Time: time.Unix(100, 0), Price: 1})
+	srv := httptest.NewServer(c.Handler())
+	t.Cleanup(srv.Close)
+	return c, ds, srv
+}
+
+// call sends a request with an optional JSON body and decodes the JSON reply into out.
+func call(t *testing.T, method, url, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestControlPlaneReadEndpoints(t *testing.T) {
+	_, _, srv := newTestControlPlane(t, &recorder{})
+
+	var posterior []posteriorView
+	if code := call(t, "GET", srv.URL+"/posterior", "", &posterior); code != 200 || len(posterior) != 1 || posterior[0].Count != 1 {
+		t.Errorf("/posterior: %d %+v, want X after one tick", code, posterior)
+	}
+	if code := call(t, "GET", srv.URL+"/posterior?symbol=Y", "", nil); code != http.StatusNotFound {
+		t.Errorf("/posterior for an unknown symbol: %d, want 404", code)
+	}
+
+	var signals []Decision
+	if code := call(t, "GET", srv.URL+"/signals?symbol=X", "", &signals); code != 200 || len(signals) != 1 || !signals[0].Time.Equal(time.Unix(100, 0)) {
+		t.Errorf("/signals: %d %+v, want the one tick's decision", code, signals)
+	}
+	var raw json.RawMessage
+	if call(t, "GET", srv.URL+"/signals?symbol=Y", "", &raw); string(raw) != "[]" {
+		t.Errorf("/signals with no decisions = %s, want []", raw)
+	}
+	if code := call(t, "GET", srv.URL+"/signals?limit=0", "", nil); code != http.StatusBadRequest {
+		t.Errorf("/signals?limit=0: %d, want 400", code)
+	}
+	if code := call(t, "POST", srv.URL+"/signals", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /signals: %d, want 405", code)
+	}
+}
+
+func TestControlPlaneParams(t *testing.T) {
+	c, _, srv := newTestControlPlane(t, &recorder{})
+
+	var p paramsView
+	if code := call(t, "PUT", srv.URL+"/params", `{"threshold": 0.5}`, &p); code != 200 || *p.Threshold != 0.5 || *p.Confidence != 0.95 {
+		t.Errorf("PUT threshold: %d %v %v, want 0.5 and the old 0.95", code, *p.Threshold, *p.Confidence)
+	}
+	for _, body := range []string{`{"confidence": 0.5}`, `{"confidence": 1}`, `{`} {
+		if code := call(t, "PUT", srv.URL+"/params", body, nil); code != http.StatusBadRequest {
+			t.Errorf("PUT %s: %d, want 400", body, code)
+		}
+	}
+	if threshold, confidence := c.Params(); threshold != 0.5 || confidence != 0.95 {
+		t.Errorf("after bad PUTs params are %v, %v, want unchanged", threshold, confidence)
+	}
+	if call(t, "GET", srv.URL+"/params", "", &p); *p.Threshold != 0.5 {
+		t.Errorf("GET threshold = %v, want 0.5", *p.Threshold)
+	}
+}
+
+func TestControlPlaneObservers(t *testing.T) {
+	rec := &recorder{}
+	_, ds, srv := newTestControlPlane(t, rec)
+
+	var raw map[string]json.RawMessage
+	call(t, "GET", srv.URL+"/observers", "", &raw)
+	if string(raw["attached"]) != "[]" || string(raw["available"]) != `["rec"]` {
+		t.Errorf("/observers = %s %s, want rec available and [] attached", raw["available"], raw["attached"])
+	}
+
+	attach := `{"symbol": "X", "name": "rec"}`
+	var views []observerView
+	if code := call(t, "POST", srv.URL+"/observers/attach", attach, &views); code != 200 || len(views) != 1 {
+		t.Fatalf("attach: %d %+v", code, views)
+	}
+	for _, tc := range []struct{ path, body string }{
+		{"/observers/attach", attach},
+		{"/observers/attach", `{"symbol": "Y", "name": "rec"}`},
+		{"/observers/attach", `{"symbol": "X", "name": "nope"}`},
+		{"/observers/detach", `{"symbol": "X", "name": "nope"}`},
+	} {
+		if code := call(t, "POST", srv.URL+tc.path, tc.body, nil); code != http.StatusConflict {
+			t.Errorf("%s %s: %d, want 409", tc.path, tc.body, code)
+		}
+	}
+
+	// A stalled operator observer must not hold up the source
+	rec.release = make(chan struct{})
+	within(t, "notify with a stalled observer", func() {
+		for i := 0; i < 3*defaultObserverBuffer; i++ {
+			ds.notify(context.Background(), float64(i))
+		}
+	})
+	if st := statsOf(&ds.observerSet, rec); st.Policy != DeliverCoalesce || st.Coalesced == 0 {
+		t.Errorf("stalled observer stats %+v, want coalesced prices", st)
+	}
+	close(rec.release)
+
+	if code := call(t, "POST", srv.URL+"/observers/detach", attach, &views); code != 200 || len(views) != 0 {
+		t.Errorf("detach: %d %+v, want nothing attached", code, views)
+	}
+	within(t, "Drain", ds.Drain)
+}
+
+func TestControlPlaneMetrics(t *testing.T) {
+	_, _, srv := newTestControlPlane(t, &recorder{})
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`estimator_confidence 0.95`,
+		`estimator_ticks_total{symbol="X"} 1`,
+		`estimator_decisions_total{symbol="X",signal="0"} 1`,
+		`estimator_posterior_mean{symbol="X"} `,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("/metrics lacks %q:\n%s", want, body)
+		}
+	}
+}
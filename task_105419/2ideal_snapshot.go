@@ -0,0 +1,251 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// estimatorSnapshotVersion is bumped whenever the binary layout changes.
+const estimatorSnapshotVersion = 1
+
+// estimatorState is the serialized form of a BayesianEstimator.
+type estimatorState struct {
+	Prior      NIGParams `json:"prior"`
+	Posterior  NIGParams `json:"posterior"`
+	Count      int       `json:"count"`
+	Forgetting float64   `json:"forgetting"`
+	WindowSize int       `json:"window_size,omitempty"`
+	Window     []float64 `json:"window,omitempty"`
+}
+
+// state copies the estimator's learned state.
+func (e *BayesianEstimator) state() estimatorState {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return estimatorState{
+		Prior:      e.prior,
+		Posterior:  e.post,
+		Count:      e.count,
+		Forgetting: e.forgetting,
+		WindowSize: e.windowSize,
+		Window:     append([]float64(nil), e.window...),
+	}
+}
+
+// validate rejects states that no estimator could have saved.
+func (s estimatorState) validate() error {
+	if err := s.Prior.validate(); err != nil {
+		return fmt.Errorf("prior: %w", err)
+	}
+	if err := s.Posterior.validate(); err != nil {
+		return fmt.Errorf("posterior: %w", err)
+	}
+	switch {
+	case s.Count < 0:
+		return fmt.Errorf("negative count %d", s.Count)
+	case !(s.Forgetting > 0 && s.Forgetting <= 1):
+		return fmt.Errorf("forgetting %v must be in (0, 1]", s.Forgetting)
+	case s.WindowSize < 0:
+		return fmt.Errorf("negative window size %d", s.WindowSize)
+	case len(s.Window) > s.WindowSize:
+		return fmt.Errorf("window of %d prices exceeds its size %d", len(s.Window), s.WindowSize)
+	case len(s.Window) > s.Count:
+		return fmt.Errorf("window of %d prices exceeds the count %d", len(s.Window), s.Count)
+	}
+	for _, x := range s.Window {
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return fmt.Errorf("window price %v is not finite", x)
+		}
+	}
+	return nil
+}
+
+// validate rejects hyperparameters outside their domain.
+func (p NIGParams) validate() error {
+	switch {
+	case math.IsNaN(p.Mu) || math.IsInf(p.Mu, 0):
+		return fmt.Errorf("mu %v is not finite", p.Mu)
+	case !(p.Kappa > 0) || math.IsInf(p.Kappa, 1):
+		return fmt.Errorf("kappa %v must be positive", p.Kappa)
+	case !(p.Alpha > 0) || math.IsInf(p.Alpha, 1):
+		return fmt.Errorf("alpha %v must be positive", p.Alpha)
+	case !(p.Beta > 0) || math.IsInf(p.Beta, 1):
+		return fmt.Errorf("beta %v must be positive", p.Beta)
+	}
+	return nil
+}
+
+// Mode returns the forgetting factor and window size the estimator runs with.
+func (e *BayesianEstimator) Mode() (forgetting float64, window int) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.forgetting, e.windowSize
+}
+
+// setState replaces the estimator's learned state with a validated one.
+func (e *BayesianEstimator) setState(s estimatorState) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.prior, e.post, e.count = s.Prior, s.Posterior, s.Count
+	e.forgetting = s.Forgetting
+	e.windowSize = s.WindowSize
+	e.window = slices.Clone(s.Window)
+	e.stale = 0
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (e *BayesianEstimator) MarshalBinary() ([]byte, error) {
+	s := e.state()
+	var buf bytes.Buffer
+	buf.WriteByte(estimatorSnapshotVersion)
+	fields := []any{
+		s.Prior, s.Posterior, int64(s.Count),
+		s.Forgetting, int64(s.WindowSize), int64(len(s.Window)), s.Window,
+	}
+	for _, f := range fields {
+		if err := binary.Write(&buf, binary.BigEndian, f); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (e *BayesianEstimator) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("empty estimator snapshot")
+	}
+	version := data[0]
+	if version != estimatorSnapshotVersion {
+		return fmt.Errorf("unsupported estimator snapshot version %d", version)
+	}
+	r := bytes.NewReader(data[1:])
+	var s estimatorState
+	var count, windowSize, windowLen int64
+	fields := []any{&s.Prior, &s.Posterior, &count, &s.Forgetting, &windowSize, &windowLen}
+	for _, f := range fields {
+		if err := binary.Read(r, binary.BigEndian, f); err != nil {
+			return fmt.Errorf("decode estimator snapshot: %w", err)
+		}
+	}
+	if windowLen < 0 || windowLen > int64(r.Len()/8) {
+		return fmt.Errorf("decode estimator snapshot: bad window length %d", windowLen)
+	}
+	s.Window = make([]float64, windowLen)
+	if err := binary.Read(r, binary.BigEndian, s.Window); err != nil {
+		return fmt.Errorf("decode estimator snapshot: %w", err)
+	}
+	s.Count = int(count)
+	s.WindowSize = int(windowSize)
+	if err := s.validate(); err != nil {
+		return fmt.Errorf("decode estimator snapshot: %w", err)
+	}
+	e.setState(s)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *BayesianEstimator) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.state())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *BayesianEstimator) UnmarshalJSON(data []byte) error {
+	var s estimatorState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if err := s.validate(); err != nil {
+		return fmt.Errorf("decode estimator snapshot: %w", err)
+	}
+	e.setState(s)
+	return nil
+}
+
+// WriteSnapshot atomically writes v's binary form to path.
+func WriteSnapshot(path string, v encoding.BinaryMarshaler) error {
+	data, err := v.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadSnapshot restores v from the snapshot at path. It returns an error
+// satisfying errors.Is(err, fs.ErrNotExist) when there is no snapshot yet.
+func ReadSnapshot(path string, v encoding.BinaryUnmarshaler) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return v.UnmarshalBinary(data)
+}
+
+// Snapshotter periodically writes a snapshot to a local file.
+type Snapshotter struct {
+	path     string
+	interval time.Duration
+	target   encoding.BinaryMarshaler
+}
+
+// NewSnapshotter creates a snapshotter writing target to path every interval.
+func NewSnapshotter(path string, interval time.Duration, target encoding.BinaryMarshaler) *Snapshotter {
+	return &Snapshotter{
+		path:     path,
+		interval: interval,
+		target:   target,
+	}
+}
+
+// Run writes a snapshot every interval and a final one when ctx is cancelled.
+func (s *Snapshotter) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.write()
+			return
+		case <-ticker.C:
+			s.write()
+		}
+	}
+}
+
+// write takes one snapshot, logging failures so a full disk does not stop trading.
+func (s *Snapshotter) write() {
+	if err := WriteSnapshot(s.path, s.target); err != nil {
+		log.Printf("snapshot %s: %v", s.path, err)
+	}
+}
@@ -0,0 +1,135 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newTrainedEstimator returns a windowed estimator that has seen some prices.
+func newTrainedEstimator() *BayesianEstimator {
+	e := NewWindowedEstimator(NIGParams{Mu: 100, Kappa: 1, Alpha: 2, Beta: 100}, 5)
+	for _, price := range []float64{101, 99.5, 104, 97, 102.25, 100.5, 98} {
+		e.Update(price)
+	}
+	return e
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	want := newTrainedEstimator()
+
+	data, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromBinary BayesianEstimator
+	if err := fromBinary.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromBinary.state(), want.state()) {
+		t.Errorf("binary round trip = %+v, want %+v", fromBinary.state(), want.state())
+	}
+
+	data, err = want.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON BayesianEstimator
+	if err := fromJSON.UnmarshalJSON(data); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON.state(), want.state()) {
+		t.Errorf("JSON round trip = %+v, want %+v", fromJSON.state(), want.state())
+	}
+
+	// The restored window keeps sliding like the original
+	want.Update(103)
+	fromBinary.Update(103)
+	if got := fromBinary.Params(); got != want.Params() {
+		t.Errorf("after update: restored posterior %+v, want %+v", got, want.Params())
+	}
+}
+
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "estimator.snap")
+	want := newTrainedEstimator()
+	if err := WriteSnapshot(path, want); err != nil {
+		t.Fatal(err)
+	}
+	got := NewBayesianEstimator(NIGParams{})
+	if err := ReadSnapshot(path, got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.state(), want.state()) {
+		t.Errorf("ReadSnapshot = %+v, want %+v", got.state(), want.state())
+	}
+}
+
+func TestSnapshotRejectsBadData(t *testing.T) {
+	good, err := newTrainedEstimator().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"version":   append([]byte{99}, good[1:]...),
+		"truncated": good[:len(good)-3],
+	} {
+		var e BayesianEstimator
+		if err := e.UnmarshalBinary(data); err == nil {
+			t.Errorf("%s: UnmarshalBinary succeeded", name)
+		}
+	}
+}
+
+func TestSnapshotRejectsInvalidState(t *testing.T) {
+	valid := `"prior":{"mu":100,"kappa":1,"alpha":2,"beta":100},"posterior":{"mu":101,"kappa":3,"alpha":3,"beta":120}`
+	for name, data := range map[string]string{
+		"negative window size": `{` + valid + `,"count":2,"forgetting":1,"window_size":-5}`,
+		"window over size":     `{` + valid + `,"count":5,"forgetting":1,"window_size":2,"window":[1,2,3,4,5]}`,
+		"window over count":    `{` + valid + `,"count":1,"forgetting":1,"window_size":3,"window":[1,2]}`,
+		"negative count":       `{` + valid + `,"count":-1,"forgetting":1}`,
+		"missing forgetting":   `{` + valid + `,"count":2}`,
+		"forgetting above 1":   `{` + valid + `,"count":2,"forgetting":1.5}`,
+		"negative forgetting":  `{` + valid + `,"count":2,"forgetting":-0.5}`,
+		"zero kappa":           `{"prior":{"mu":100,"kappa":0,"alpha":2,"beta":100},"posterior":{"mu":101,"kappa":3,"alpha":3,"beta":120},"count":2,"forgetting":1}`,
+		"negative beta":        `{"prior":{"mu":100,"kappa":1,"alpha":2,"beta":100},"posterior":{"mu":101,"kappa":3,"alpha":3,"beta":-1},"count":2,"forgetting":1}`,
+	} {
+		e := newTrainedEstimator()
+		want := e.state()
+		if err := e.UnmarshalJSON([]byte(data)); err == nil {
+			t.Errorf("%s: UnmarshalJSON succeeded", name)
+		}
+		if !reflect.DeepEqual(e.state(), want) {
+			t.Errorf("%s: a rejected snapshot changed the estimator", name)
+		}
+	}
+
+	if err := newTrainedEstimator().UnmarshalJSON([]byte(`{` + valid + `,"count":2,"forgetting":1}`)); err != nil {
+		t.Errorf("valid snapshot rejected: %v", err)
+	}
+
+	// The binary path can carry values JSON cannot
+	bad := newTrainedEstimator()
+	bad.post.Beta = math.NaN()
+	data, err := bad.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e BayesianEstimator
+	if err := e.UnmarshalBinary(data); err == nil {
+		t.Error("UnmarshalBinary accepted a NaN beta")
+	}
+	bad = newTrainedEstimator()
+	bad.windowSize = -5
+	if data, err = bad.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.UnmarshalBinary(data); err == nil {
+		t.Error("UnmarshalBinary accepted a negative window size")
+	}
+}
@@ -0,0 +1,401 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// streamHeartbeat is how often idle streams send a keep-alive.
+const streamHeartbeat = 15 * time.Second
+
+// websocketGUID is the key suffix defined by RFC 6455 for the handshake.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketWriteTimeout bounds each write, so a client that stops reading
+// is disconnected instead of blocking its stream forever.
+const websocketWriteTimeout = 10 * time.Second
+
+// SignalEvent is the streamed form of a Decision.
+type SignalEvent struct {
+	Time        time.Time `json:"time"`
+	Symbol      string    `json:"symbol"`
+	Signal      int       `json:"signal"`
+	Probability float64   `json:"probability"`
+	Mean        float64   `json:"posterior_mean"`
+}
+
+// streamClient is one subscriber of a SignalHub.
+type streamClient struct {
+	symbol  string // Only events for this symbol, or all if ""
+	events  chan SignalEvent
+	dropped atomic.Uint64
+}
+
+// SignalHub broadcasts decisions to streaming subscribers. It is registered
+// as a decision observer with ControlPlane.OnDecision and delivers to every
+// client through its own buffer, dropping events for clients that fall
+// behind rather than stalling the estimators.
+type SignalHub struct {
+	clients map[*streamClient]struct{}
+	buffer  int
+	closed  bool
+	mux     sync.Mutex
+}
+
+// NewSignalHub creates a hub that buffers up to buffer events per client.
+func NewSignalHub(buffer int) *SignalHub {
+	return &SignalHub{
+		clients: make(map[*streamClient]struct{}),
+		buffer:  buffer,
+	}
+}
+
+// Publish sends d to every subscriber interested in its symbol.
+func (h *SignalHub) Publish(d Decision) {
+	ev := SignalEvent{
+		Time:        d.Time,
+		Symbol:      d.Symbol,
+		Signal:      d.Signal,
+		Probability: d.Probability,
+		Mean:        d.Mean,
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for c := range h.clients {
+		if c.symbol != "" && c.symbol != ev.Symbol {
+			continue
+		}
+		select {
+		case c.events <- ev:
+		default:
+			c.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe registers a client for symbol, or every symbol if it is "". The
+// returned channel is closed by cancel or when the hub is closed.
+func (h *SignalHub) Subscribe(symbol string) (events <-chan SignalEvent, cancel func()) {
+	c := &streamClient{symbol: symbol, events: make(chan SignalEvent, h.buffer)}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if h.closed {
+		close(c.events)
+		return c.events, func() {}
+	}
+	h.clients[c] = struct{}{}
+	return c.events, func() { h.remove(c) }
+}
+
+// remove unregisters c and closes its channel, once.
+func (h *SignalHub) remove(c *streamClient) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.events)
+	}
+}
+
+// Clients returns the number of connected subscribers.
+func (h *SignalHub) Clients() int {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return len(h.clients)
+}
+
+// Close disconnects every subscriber and rejects new ones.
+func (h *SignalHub) Close() {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		delete(h.clients, c)
+		close(c.events)
+	}
+}
+
+// Handler returns the streaming API:
+//
+//	GET /stream[?symbol=S]     Server-Sent Events, one "decision" event per decision
+//	GET /stream/ws[?symbol=S]  WebSocket, one JSON text message per decision
+func (h *SignalHub) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", h.ServeSSE)
+	mux.HandleFunc("/stream/ws", h.ServeWebSocket)
+	return mux
+}
+
+// ServeSSE streams decisions as Server-Sent Events until the client goes away.
+func (h *SignalHub) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+	events, cancel := h.Subscribe(r.URL.Query().Get("symbol"))
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: decision\ndata: %s\n\n", data)
+		}
+		flusher.Flush()
+	}
+}
+
+// ServeWebSocket upgrades the connection and streams decisions as JSON text
+// messages until either side closes it. Messages from the client are read
+// only to answer pings and notice the close.
+func (h *SignalHub) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		httpError(w, http.StatusBadRequest, errors.New("not a websocket handshake"))
+		return
+	}
+	if v := r.Header.Get("Sec-WebSocket-Version"); v != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		httpError(w, http.StatusUpgradeRequired, fmt.Errorf("unsupported websocket version %q", v))
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		httpError(w, http.StatusInternalServerError, errors.New("websocket not supported"))
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	events, cancel := h.Subscribe(r.URL.Query().Get("symbol"))
+	defer cancel()
+
+	ws := &wsConn{conn: conn, w: rw.Writer}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ws.readLoop(rw.Reader)
+	}()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-heartbeat.C:
+			err = ws.writeFrame(wsPing, nil)
+		case ev, ok := <-events:
+			if !ok {
+				ws.writeFrame(wsClose, nil)
+				return
+			}
+			var data []byte
+			if data, err = json.Marshal(ev); err == nil {
+				err = ws.writeFrame(wsText, data)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// headerContains reports whether a comma-separated header holds token, ignoring case.
+func headerContains(header http.Header, name, token string) bool {
+	for _, v := range header.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WebSocket opcodes used by wsConn.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// WebSocket close status codes sent by wsConn.
+const (
+	wsProtocolError = 1002
+	wsMessageTooBig = 1009
+)
+
+// wsCloseError is a client error that ends the connection with a close status.
+type wsCloseError struct {
+	code   uint16
+	reason string
+}
+
+// Error implements error.
+func (e *wsCloseError) Error() string {
+	return fmt.Sprintf("websocket %s (close %d)", e.reason, e.code)
+}
+
+// wsConn is the server side of a WebSocket connection: unmasked frames out,
+// masked frames in.
+type wsConn struct {
+	conn net.Conn
+	w    *bufio.Writer
+	mux  sync.Mutex
+}
+
+// writeFrame writes one final frame with the given opcode and payload.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(websocketWriteTimeout)); err != nil {
+		return err
+	}
+
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if _, err := c.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(payload); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// readLoop consumes client frames, answering pings, until the client closes
+// the connection or a read fails. Frames that break the protocol are
+// answered with a close frame carrying the reason.
+func (c *wsConn) readLoop(r *bufio.Reader) {
+	for {
+		opcode, payload, err := readFrame(r)
+		var closeErr *wsCloseError
+		if errors.As(err, &closeErr) {
+			c.writeFrame(wsClose, binary.BigEndian.AppendUint16(nil, closeErr.code))
+		}
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsClose:
+			c.writeFrame(wsClose, nil)
+			return
+		case wsPing:
+			if c.writeFrame(wsPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+// maxClientFrame bounds the payload accepted from a client; clients only
+// send control frames and the occasional short message.
+const maxClientFrame = 1 << 16
+
+// readFrame reads one client frame, unmasking its payload. RFC 6455 requires
+// clients to mask every frame.
+func readFrame(r *bufio.Reader) (opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode = head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if !masked {
+		return 0, nil, &wsCloseError{wsProtocolError, "unmasked client frame"}
+	}
+	if n > maxClientFrame {
+		return 0, nil, &wsCloseError{wsMessageTooBig, fmt.Sprintf("frame of %d bytes", n)}
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
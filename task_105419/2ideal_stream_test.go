@@ -0,0 +1,169 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsHandshake is the RFC 6455 sample handshake, whose accept key is known.
+const wsHandshake = "GET /stream/ws?symbol=X HTTP/1.1\r\nHost: test\r\n" +
+	"Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+
+// dialStream connects to the hub's WebSocket and returns the connection
+// after the handshake, once the hub has subscribed it.
+func dialStream(t *testing.T, hub *SignalHub) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	srv := httptest.NewServer(hub.Handler())
+	t.Cleanup(srv.Close)
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, wsHandshake); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake status %d, want 101", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Sec-WebSocket-Accept = %q", got)
+	}
+	for hub.Clients() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	return conn, r
+}
+
+// clientFrame encodes a final client frame, masked unless mask is nil.
+func clientFrame(opcode byte, payload []byte, mask []byte) []byte {
+	frame := []byte{0x80 | opcode, byte(len(payload))}
+	if mask == nil {
+		return append(frame, payload...)
+	}
+	frame[1] |= 0x80
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+// serverFrame reads one unmasked frame sent by the server.
+func serverFrame(t *testing.T, r *bufio.Reader) (opcode byte, payload []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		t.Fatal(err)
+	}
+	if head[1]&0x80 != 0 {
+		t.Fatal("server frame is masked")
+	}
+	n := int(head[1])
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return head[0] & 0x0F, payload
+}
+
+func TestWebSocketStream(t *testing.T) {
+	hub := NewSignalHub(8)
+	conn, r := dialStream(t, hub)
+
+	hub.Publish(Decision{Symbol: "Y", Signal: -1})
+	hub.Publish(Decision{Symbol: "X", Signal: 1, Probability: 0.99})
+	opcode, payload := serverFrame(t, r)
+	var ev SignalEvent
+	if err := json.Unmarshal(payload, &ev); opcode != wsText || err != nil || ev.Symbol != "X" || ev.Signal != 1 {
+		t.Fatalf("got opcode %d %s, want the X decision as text", opcode, payload)
+	}
+
+	conn.Write(clientFrame(wsPing, []byte("hi"), []byte{1, 2, 3, 4}))
+	if opcode, payload := serverFrame(t, r); opcode != wsPong || string(payload) != "hi" {
+		t.Errorf("ping answered with opcode %d %q, want pong \"hi\"", opcode, payload)
+	}
+
+	conn.Write(clientFrame(wsClose, nil, []byte{1, 2, 3, 4}))
+	if opcode, _ := serverFrame(t, r); opcode != wsClose {
+		t.Errorf("close answered with opcode %d", opcode)
+	}
+}
+
+func TestWebSocketRejectsUnmaskedFrames(t *testing.T) {
+	hub := NewSignalHub(8)
+	conn, r := dialStream(t, hub)
+
+	conn.Write(clientFrame(wsPing, []byte("hi"), nil))
+	opcode, payload := serverFrame(t, r)
+	if opcode != wsClose || len(payload) != 2 || binary.BigEndian.Uint16(payload) != wsProtocolError {
+		t.Errorf("unmasked frame answered with opcode %d %v, want close 1002", opcode, payload)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("connection still open after the close: %v", err)
+	}
+}
+
+func TestWebSocketHandshakeErrors(t *testing.T) {
+	srv := httptest.NewServer(NewSignalHub(8).Handler())
+	defer srv.Close()
+	upgrade := map[string]string{
+		"Upgrade":               "websocket",
+		"Connection":            "Upgrade",
+		"Sec-WebSocket-Key":     "dGhlIHNhbXBsZSBub25jZQ==",
+		"Sec-WebSocket-Version": "13",
+	}
+	for _, tc := range []struct {
+		drop, version string
+		code          int
+	}{
+		{drop: "Upgrade", code: http.StatusBadRequest},
+		{drop: "Sec-WebSocket-Key", code: http.StatusBadRequest},
+		{drop: "Sec-WebSocket-Version", code: http.StatusUpgradeRequired},
+		{version: "8", code: http.StatusUpgradeRequired},
+	} {
+		req, _ := http.NewRequest("GET", srv.URL+"/stream/ws", nil)
+		for k, v := range upgrade {
+			if k != tc.drop {
+				req.Header.Set(k, v)
+			}
+		}
+		if tc.version != "" {
+			req.Header.Set("Sec-WebSocket-Version", tc.version)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.code {
+			t.Errorf("without %q, version %q: status %d, want %d", tc.drop, tc.version, resp.StatusCode, tc.code)
+		}
+		if tc.code == http.StatusUpgradeRequired && !strings.Contains(resp.Header.Get("Sec-WebSocket-Version"), "13") {
+			t.Errorf("426 without Sec-WebSocket-Version: 13")
+		}
+	}
+}
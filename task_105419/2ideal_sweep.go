@@ -0,0 +1,288 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SweepParams is one candidate configuration of the estimator's signal.
+type SweepParams struct {
+	Prior      NIGParams
+	Threshold  float64
+	Confidence float64
+}
+
+// String formats the parameters on one line.
+func (p SweepParams) String() string {
+	return fmt.Sprintf("mu=%.4g kappa=%.4g alpha=%.4g beta=%.4g threshold=%.4g confidence=%.4g",
+		p.Prior.Mu, p.Prior.Kappa, p.Prior.Alpha, p.Prior.Beta, p.Threshold, p.Confidence)
+}
+
+// SweepSpace lists the values searched for each parameter.
+type SweepSpace struct {
+	Mu, Kappa, Alpha, Beta []float64
+	Threshold, Confidence  []float64
+}
+
+// DefaultSweepSpace searches around a baseline prior and threshold: the prior
+// strength and scale over two orders of magnitude and three confidence levels.
+func DefaultSweepSpace(prior NIGParams, threshold float64) SweepSpace {
+	return SweepSpace{
+		Mu:         []float64{prior.Mu},
+		Kappa:      []float64{prior.Kappa / 10, prior.Kappa, prior.Kappa * 10},
+		Alpha:      []float64{prior.Alpha},
+		Beta:       []float64{prior.Beta / 10, prior.Beta, prior.Beta * 10},
+		Threshold:  []float64{threshold},
+		Confidence: []float64{0.9, 0.95, 0.99},
+	}
+}
+
+// ParseSweepSpace overrides dimensions of base from a spec such as
+// "kappa=0.1,1,10;confidence=0.9,0.95". Keys are mu, kappa, alpha, beta,
+// threshold and confidence.
+func ParseSweepSpace(spec string, base SweepSpace) (SweepSpace, error) {
+	dims := map[string]*[]float64{
+		"mu":         &base.Mu,
+		"kappa":      &base.Kappa,
+		"alpha":      &base.Alpha,
+		"beta":       &base.Beta,
+		"threshold":  &base.Threshold,
+		"confidence": &base.Confidence,
+	}
+	for _, part := range strings.Split(spec, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		key, list, ok := strings.Cut(part, "=")
+		dim, known := dims[strings.TrimSpace(key)]
+		if !ok || !known {
+			return base, fmt.Errorf("bad sweep dimension %q", part)
+		}
+		var values []float64
+		for _, s := range strings.Split(list, ",") {
+			v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return base, fmt.Errorf("sweep dimension %s: %w", key, err)
+			}
+			values = append(values, v)
+		}
+		*dim = values
+	}
+	for _, c := range base.Confidence {
+		if !(c > 0.5 && c < 1) {
+			return base, fmt.Errorf("confidence %v must be in (0.5, 1)", c)
+		}
+	}
+	return base, nil
+}
+
+// Grid returns every combination of the values in s.
+func (s SweepSpace) Grid() []SweepParams {
+	var out []SweepParams
+	for _, mu := range s.Mu {
+		for _, kappa := range s.Kappa {
+			for _, alpha := range s.Alpha {
+				for _, beta := range s.Beta {
+					for _, threshold := range s.Threshold {
+						for _, confidence := range s.Confidence {
+							out = append(out, SweepParams{
+								Prior:      NIGParams{Mu: mu, Kappa: kappa, Alpha: alpha, Beta: beta},
+								Threshold:  threshold,
+								Confidence: confidence,
+							})
+						}
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// Random draws n candidates uniformly between the smallest and largest value
+// of each dimension. Kappa and Beta, which are scales, are drawn log-uniformly.
+func (s SweepSpace) Random(rng *rand.Rand, n int) []SweepParams {
+	out := make([]SweepParams, n)
+	for i := range out {
+		out[i] = SweepParams{
+			Prior: NIGParams{
+				Mu:    sampleRange(rng, s.Mu, false),
+				Kappa: sampleRange(rng, s.Kappa, true),
+				Alpha: sampleRange(rng, s.Alpha, false),
+				Beta:  sampleRange(rng, s.Beta, true),
+			},
+			Threshold:  sampleRange(rng, s.Threshold, false),
+			Confidence: sampleRange(rng, s.Confidence, false),
+		}
+	}
+	return out
+}
+
+// sampleRange draws uniformly between the extremes of values.
+func sampleRange(rng *rand.Rand, values []float64, logScale bool) float64 {
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	u := rng.Float64()
+	if logScale && lo > 0 {
+		return math.Exp(math.Log(lo) + u*(math.Log(hi)-math.Log(lo)))
+	}
+	return lo + u*(hi-lo)
+}
+
+// SweepConfig holds what is fixed across a sweep.
+type SweepConfig struct {
+	Backtest   BacktestConfig
+	Returns    bool       // Model returns of Kind instead of price levels
+	Kind       ReturnKind // Used when Returns is set
+	Normalize  bool       // Divide returns by their EWMA volatility
+	Forgetting float64    // Estimator forgetting factor (1 = never forget)
+	Folds      int        // Walk-forward folds
+}
+
+// FoldResult is the outcome of one walk-forward fold: the candidate that did
+// best on the training span and how it then did on the following test span.
+type FoldResult struct {
+	Fold       int
+	TrainBars  int // Bars 0..TrainBars-1 were used for selection
+	TestBars   int // The next TestBars bars were traded out of sample
+	Best       SweepParams
+	TrainScore float64 // Sharpe ratio in sample
+	Test       BacktestReport
+}
+
+// String formats the fold on one line.
+func (r FoldResult) String() string {
+	return fmt.Sprintf("fold %d train=%d test=%d in-sample sharpe=%.2f out-of-sample %v | %v",
+		r.Fold, r.TrainBars, r.TestBars, r.TrainScore, r.Test, r.Best)
+}
+
+// WalkForward splits prices into Folds+1 equal blocks. Fold k selects the
+// candidate with the best Sharpe ratio over blocks 0..k and trades it on
+// block k+1, with the estimator warmed up on everything before. Only
+// out-of-sample results should be used to judge the parameters.
+func WalkForward(prices []float64, candidates []SweepParams, cfg SweepConfig) ([]FoldResult, error) {
+	if cfg.Folds < 1 {
+		return nil, fmt.Errorf("walk-forward needs at least one fold, got %d", cfg.Folds)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no candidates to sweep")
+	}
+	block := len(prices) / (cfg.Folds + 1)
+	if block < 2 {
+		return nil, fmt.Errorf("%d bars are too few for %d folds", len(prices), cfg.Folds)
+	}
+
+	results := make([]FoldResult, cfg.Folds)
+	for k := range results {
+		train := (k + 1) * block
+		end := train + block
+		if k == cfg.Folds-1 {
+			end = len(prices) // The last fold takes the remainder
+		}
+
+		scores := make([]float64, len(candidates))
+		parallel(len(candidates), func(i int) {
+			scores[i] = runSweepBacktest(prices[:train], 0, candidates[i], cfg).Sharpe
+		})
+		best := 0
+		for i, s := range scores {
+			if s > scores[best] {
+				best = i
+			}
+		}
+
+		results[k] = FoldResult{
+			Fold:       k + 1,
+			TrainBars:  train,
+			TestBars:   end - train,
+			Best:       candidates[best],
+			TrainScore: scores[best],
+			Test:       runSweepBacktest(prices[:end], train, candidates[best], cfg),
+		}
+	}
+	return results, nil
+}
+
+// runSweepBacktest feeds prices to a fresh estimator configured by p and
+// backtests its signal from bar start on.
+func runSweepBacktest(prices []float64, start int, p SweepParams, cfg SweepConfig) BacktestReport {
+	estimator := NewBayesianEstimator(p.Prior)
+	if cfg.Forgetting > 0 && cfg.Forgetting < 1 {
+		estimator = NewDiscountedEstimator(p.Prior, cfg.Forgetting)
+	}
+	var input Observer = estimator
+	if cfg.Returns {
+		rt := NewReturnsTransform(cfg.Kind)
+		if cfg.Normalize {
+			rt = NewNormalizedReturnsTransform(cfg.Kind, 0.94)
+		}
+		rt.Attach(estimator)
+		input = rt
+	}
+	bt := NewBacktester(cfg.Backtest, nil)
+	for i, price := range prices {
+		input.Update(price)
+		if i >= start {
+			bt.Step(price, estimator.GenerateSignal(p.Threshold, p.Confidence).Signal)
+		}
+	}
+	return bt.Report()
+}
+
+// parallel calls fn(0..n-1) on up to GOMAXPROCS goroutines.
+func parallel(n int, fn func(i int)) {
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(n, runtime.GOMAXPROCS(0)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// SweepSummary aggregates the out-of-sample results of a walk-forward run.
+type SweepSummary struct {
+	Folds       int
+	TotalReturn float64 // Compounded across test spans
+	MeanSharpe  float64
+	WorstFold   float64 // Lowest test return
+	Trades      int
+}
+
+// Summarize aggregates fold results.
+func Summarize(results []FoldResult) SweepSummary {
+	s := SweepSummary{Folds: len(results), TotalReturn: 1, WorstFold: math.Inf(1)}
+	for _, r := range results {
+		s.TotalReturn *= 1 + r.Test.TotalReturn
+		s.MeanSharpe += r.Test.Sharpe / float64(len(results))
+		s.WorstFold = math.Min(s.WorstFold, r.Test.TotalReturn)
+		s.Trades += r.Test.Trades
+	}
+	s.TotalReturn--
+	return s
+}
+
+// String formats the summary on one line.
+func (s SweepSummary) String() string {
+	return fmt.Sprintf("out-of-sample over %d folds: return=%.2f%% mean sharpe=%.2f worst fold=%.2f%% trades=%d",
+		s.Folds, 100*s.TotalReturn, s.MeanSharpe, 100*s.WorstFold, s.Trades)
+}
@@ -0,0 +1,134 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tick is a single market data print for one instrument.
+type Tick struct {
+	Symbol string
+	Time   time.Time
+	Price  float64
+	Size   float64
+}
+
+// TickObserver represents an observer of ticks that need the symbol, time or size.
+type TickObserver interface {
+	OnTick(tick Tick)
+}
+
+// TickObserverFunc adapts a function to the TickObserver interface.
+type TickObserverFunc func(tick Tick)
+
+// OnTick implements TickObserver.
+func (f TickObserverFunc) OnTick(tick Tick) {
+	f(tick)
+}
+
+// priceObserver adapts a price Observer to the TickObserver interface.
+type priceObserver struct {
+	Observer
+}
+
+// OnTick implements TickObserver.
+func (p priceObserver) OnTick(tick Tick) {
+	p.Update(tick.Price)
+}
+
+// MultiSource routes ticks by symbol, keeping a separate BayesianEstimator per
+// instrument and notifying observers subscribed to that instrument.
+type MultiSource struct {
+	newEstimator func(symbol string) *BayesianEstimator
+	estimators   map[string]*BayesianEstimator
+	all          []TickObserver            // Subscribers to every symbol
+	bySymbol     map[string][]TickObserver // Subscribers to specific symbols
+	mux          sync.RWMutex
+}
+
+// NewMultiSource creates a multi-symbol source. newEstimator is called the
+// first time a symbol is seen.
+func NewMultiSource(newEstimator func(symbol string) *BayesianEstimator) *MultiSource {
+	return &MultiSource{
+		newEstimator: newEstimator,
+		estimators:   make(map[string]*BayesianEstimator),
+		bySymbol:     make(map[string][]TickObserver),
+	}
+}
+
+// Subscribe registers an observer for the given symbols, or for every symbol if none are given.
+func (m *MultiSource) Subscribe(observer TickObserver, symbols ...string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if len(symbols) == 0 {
+		m.all = append(m.all, observer)
+		return
+	}
+	for _, s := range symbols {
+		m.bySymbol[s] = append(m.bySymbol[s], observer)
+	}
+}
+
+// Attach registers a price-only observer for the given symbols.
+func (m *MultiSource) Attach(observer Observer, symbols ...string) {
+	m.Subscribe(priceObserver{observer}, symbols...)
+}
+
+// Estimator returns the estimator for symbol, creating it if needed.
+func (m *MultiSource) Estimator(symbol string) *BayesianEstimator {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	e, ok := m.estimators[symbol]
+	if !ok {
+		e = m.newEstimator(symbol)
+		m.estimators[symbol] = e
+	}
+	return e
+}
+
+// Symbols returns the symbols seen so far in sorted order.
+func (m *MultiSource) Symbols() []string {
+	m.mux.RLock()
+	defer m.mux.RUnlock()
+	symbols := make([]string, 0, len(m.estimators))
+	for s := range m.estimators {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+// Publish updates the symbol's estimator and fans the tick out to its subscribers.
+func (m *MultiSource) Publish(tick Tick) {
+	m.Estimator(tick.Symbol).Update(tick.Price)
+
+	// Observers run without the lock so they may query the source
+	m.mux.RLock()
+	observers := append(slices.Clone(m.bySymbol[tick.Symbol]), m.all...)
+	m.mux.RUnlock()
+	for _, observer := range observers {
+		observer.OnTick(tick)
+	}
+}
+
+// Feed returns an Observer that publishes each price it receives as a tick
+// for symbol, so single-instrument sources can drive a MultiSource.
+func (m *MultiSource) Feed(symbol string) Observer {
+	return symbolFeed{source: m, symbol: symbol}
+}
+
+// symbolFeed stamps prices with a symbol and the arrival time.
+type symbolFeed struct {
+	source *MultiSource
+	symbol string
+}
+
+// Update implements Observer.
+func (f symbolFeed) Update(price float64) {
+	f.source.Publish(Tick{Symbol: f.symbol, Time: time.Now(), Price: price})
+}
@@ -0,0 +1,91 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWindowedEstimatorMatchesRebuild(t *testing.T) {
+	prior := NIGParams{Mu: 100, Kappa: 1, Alpha: 2, Beta: 100}
+	const size = 50
+	e := NewWindowedEstimator(prior, size)
+	rng := rand.New(rand.NewSource(1))
+	var prices []float64
+	// 537 is not a multiple of the window, so the posterior has been downdated
+	// since the last periodic rebuild.
+	for i := 0; i < 537; i++ {
+		price := 100 + 10*rng.NormFloat64() + float64(i)/10
+		prices = append(prices, price)
+		e.Update(price)
+	}
+
+	want := prior
+	for _, x := range prices[len(prices)-size:] {
+		want = want.update(x)
+	}
+	got := e.Params()
+	for _, f := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"mu", got.Mu, want.Mu},
+		{"kappa", got.Kappa, want.Kappa},
+		{"alpha", got.Alpha, want.Alpha},
+		{"beta", got.Beta, want.Beta},
+	} {
+		if math.Abs(f.got-f.want) > 1e-9*math.Max(1, math.Abs(f.want)) {
+			t.Errorf("%s = %.12g, want %.12g", f.name, f.got, f.want)
+		}
+	}
+	if n := e.Count(); n != len(prices) {
+		t.Errorf("Count() = %d, want %d", n, len(prices))
+	}
+}
+
+func TestNIGRemoveUndoesUpdate(t *testing.T) {
+	p := NIGParams{Mu: 1, Kappa: 3, Alpha: 4, Beta: 5}
+	got := p.update(7.5).remove(7.5)
+	if math.Abs(got.Mu-p.Mu) > 1e-12 || math.Abs(got.Kappa-p.Kappa) > 1e-12 ||
+		math.Abs(got.Alpha-p.Alpha) > 1e-12 || math.Abs(got.Beta-p.Beta) > 1e-12 {
+		t.Errorf("update then remove = %+v, want %+v", got, p)
+	}
+}
+
+func TestSyntheticSourceReproducible(t *testing.T) {
+	for _, process := range []string{"noise", "gbm", "jump", "regime"} {
+		a, err := newSyntheticMarket(process, time.Second, 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := newSyntheticMarket(process, time.Second, 42)
+		c, _ := newSyntheticMarket(process, time.Second, 43)
+
+		first, second, other := a.Generate(200), b.Generate(200), c.Generate(200)
+		if !slices.Equal(first, second) {
+			t.Errorf("%s: same seed generated different prices", process)
+		}
+		if slices.Equal(first, other) {
+			t.Errorf("%s: different seeds generated the same prices", process)
+		}
+	}
+}
+
+func TestGenerateSignalUsesClock(t *testing.T) {
+	bars := minuteBars(3)
+	clock := NewBarClock(bars)
+	e := NewBayesianEstimator(NIGParams{Mu: 0, Kappa: 1, Alpha: 2, Beta: 1})
+	e.SetClock(clock.Now)
+	for _, bar := range bars {
+		clock.Update(bar.Close)
+		e.Update(bar.Close)
+		if d := e.GenerateSignal(0, 0.95); !d.Time.Equal(bar.Time) {
+			t.Errorf("decision at %v, want the bar's %v", d.Time, bar.Time)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+//go:build 2ideal
+// +build 2ideal
+
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// VolatilityEstimator is an observer of returns keeping an Inverse-Gamma
+// posterior over their variance, assuming a zero mean return. Past evidence
+// is discounted on every update so the estimate tracks volatility clusters.
+type VolatilityEstimator struct {
+	priorShape, priorScale float64
+	shape, scale           float64
+	discount               float64 // Weight kept by past evidence on each update
+	count                  int
+	mux                    sync.Mutex
+}
+
+// NewVolatilityEstimator creates an estimator with an InvGamma(shape, scale)
+// prior on the variance and the given discount (1 never forgets).
+func NewVolatilityEstimator(shape, scale, discount float64) *VolatilityEstimator {
+	return &VolatilityEstimator{
+		priorShape: shape,
+		priorScale: scale,
+		shape:      shape,
+		scale:      scale,
+		discount:   discount,
+	}
+}
+
+// Update implements Observer.
+func (v *VolatilityEstimator) Update(ret float64) {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	v.shape = v.priorShape + v.discount*(v.shape-v.priorShape) + 0.5
+	v.scale = v.priorScale + v.discount*(v.scale-v.priorScale) + ret*ret/2
+	v.count++
+}
+
+// Variance returns the posterior mean of the variance.
+func (v *VolatilityEstimator) Variance() float64 {
+	v.mux.Lock()
+	defer v.mux.Unlock()
+	if v.shape <= 1 {
+		return v.scale / v.shape // Posterior mode-like fallback while the mean is undefined
+	}
+	return v.scale / (v.shape - 1)
+}
+
+// Volatility returns the square root of the posterior mean variance.
+func (v *VolatilityEstimator) Volatility() float64 {
+	return math.Sqrt(v.Variance())
+}
+
+// VolTargetSizer scales positions so that their expected volatility per
+// period matches TargetVol, as a fraction of equity.
+type VolTargetSizer struct {
+	TargetVol   float64 // Per-period volatility target, e.g. 0.01 for 1%
+	Vol         *VolatilityEstimator
+	MaxLeverage float64 // Cap on position notional over equity; 0 means 1
+}
+
+// Target implements Sizer.
+func (s VolTargetSizer) Target(signal int, price, equity float64) float64 {
+	vol := s.Vol.Volatility()
+	if vol <= 0 {
+		return 0
+	}
+	return float64(signal) * capLeverage(s.TargetVol/vol, s.MaxLeverage) * equity / price
+}
+
+// KellySizer sizes positions by a fraction of the Kelly criterion mu/sigma^2,
+// using the posterior mean return of Mean and the variance from Vol.
+type KellySizer struct {
+	Mean        Model
+	Vol         *VolatilityEstimator
+	Fraction    float64 // Fraction of full Kelly, e.g. 0.5
+	MaxLeverage float64 // Cap on position notional over equity; 0 means 1
+}
+
+// Target implements Sizer.
+func (s KellySizer) Target(signal int, price, equity float64) float64 {
+	variance := s.Vol.Variance()
+	if variance <= 0 {
+		return 0
+	}
+	kelly := s.Fraction * s.Mean.Posterior().Mean / variance
+	if kelly*float64(signal) <= 0 {
+		return 0 // The posterior mean disagrees with the signal
+	}
+	return float64(signal) * capLeverage(math.Abs(kelly), s.MaxLeverage) * equity / price
+}
+
+// capLeverage limits a leverage to max, treating a zero max as 1.
+func capLeverage(leverage, max float64) float64 {
+	if max == 0 {
+		max = 1
+	}
+	return math.Min(leverage, max)
+}